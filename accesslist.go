@@ -0,0 +1,227 @@
+// Copyright(C) 2024 Github Inc. All Rights Reserved.
+// Author: metrue8@gmail.com
+// Date:   2024/01/03
+
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// AccessList 主体名单, 用于白名单/黑名单, 支持精确匹配的主体及 IP 的 CIDR 网段
+//
+// 名单可从 Redis 集合加载(见 Client.LoadAccessList), 各实例通过 Reload 共享同一份名单; 并发读取及重新加载是安全的
+type AccessList struct {
+	client   *Client             // [-] 加载名单的客户端     -- LoadAccessList 传入
+	key      string              // [-] 名单对应的 Redis 集合 -- LoadAccessList 传入
+	mutex    sync.RWMutex        // [X] 保护以下字段
+	subjects map[string]struct{} // [X] 精确匹配的主体, IP 按标准格式存储
+	networks []*net.IPNet        // [X] CIDR 网段
+}
+
+// NewAccessList 创建主体名单, 名单项为主体(如用户ID、IP)或 CIDR 网段(如 10.0.0.0/8), CIDR 非法时返回错误
+func NewAccessList(entries ...string) (*AccessList, error) {
+	l := &AccessList{subjects: make(map[string]struct{})}
+	if err := l.Add(entries...); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// LoadAccessList 使用默认客户端从 Redis 集合加载主体名单
+func LoadAccessList(ctx context.Context, key string) (*AccessList, error) {
+	return defaultClient.LoadAccessList(ctx, key)
+}
+
+// LoadAccessList 从 Redis 集合加载主体名单, 集合成员即名单项; 之后可调用 Reload 重新加载
+func (c *Client) LoadAccessList(ctx context.Context, key string) (*AccessList, error) {
+	l := &AccessList{
+		client:   c,
+		key:      key,
+		subjects: make(map[string]struct{}),
+	}
+	if err := l.Reload(ctx); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Reload 从 Redis 集合重新加载名单并整体替换, 仅适用于 LoadAccessList 创建的名单; 加载失败时保留原名单
+func (l *AccessList) Reload(ctx context.Context) error {
+	if l.client == nil {
+		return fmt.Errorf("%w: access list is not loaded from redis", ErrUnsupported)
+	}
+
+	members, err := l.client.rdb.SMembers(ctx, l.key).Result()
+	if err != nil {
+		return wrapBackendErr(err)
+	}
+
+	fresh, err := NewAccessList(members...)
+	if err != nil {
+		return fmt.Errorf("access list %q: %w", l.key, err)
+	}
+
+	l.mutex.Lock()
+	l.subjects, l.networks = fresh.subjects, fresh.networks
+	l.mutex.Unlock()
+	return nil
+}
+
+// Add 追加名单项, 仅修改本地名单, 不写入 Redis 集合; 任一 CIDR 非法时不追加任何名单项
+func (l *AccessList) Add(entries ...string) error {
+	subjects := make([]string, 0, len(entries))
+	networks := make([]*net.IPNet, 0)
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+
+		if strings.Contains(entry, "/") {
+			_, network, err := net.ParseCIDR(entry)
+			if err != nil {
+				return fmt.Errorf("%w: invalid CIDR %q", ErrInvalidOptions, entry)
+			}
+			networks = append(networks, network)
+			continue
+		}
+		subjects = append(subjects, normalizeSubject(entry))
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for _, subject := range subjects {
+		l.subjects[subject] = struct{}{}
+	}
+	l.networks = append(l.networks, networks...)
+	return nil
+}
+
+// Contains 主体是否在名单中: 精确匹配主体, 主体为 IP 时同时匹配 CIDR 网段
+func (l *AccessList) Contains(subject string) bool {
+	if l == nil || len(subject) == 0 {
+		return false
+	}
+
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	if _, ok := l.subjects[normalizeSubject(subject)]; ok {
+		return true
+	}
+
+	if ip := net.ParseIP(subject); ip != nil {
+		for _, network := range l.networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Len 返回名单项个数
+func (l *AccessList) Len() int {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	return len(l.subjects) + len(l.networks)
+}
+
+// normalizeSubject 主体为 IP 时转换为标准格式, 如 ::ffff:10.0.0.1 与 10.0.0.1 视为同一主体
+func normalizeSubject(subject string) string {
+	if ip := net.ParseIP(subject); ip != nil {
+		return ip.String()
+	}
+
+	return subject
+}
+
+// WithAllowList 设置白名单, 主体维度的取值在名单中时直接放行, 不执行 Lua 脚本也不消耗许可
+func (r *RateLimiter) WithAllowList(l *AccessList) *RateLimiter {
+	r.allowList = l
+	return r
+}
+
+// WithDenyList 设置黑名单, 主体维度的取值在名单中时直接拒绝(RetryAfter 为 -1), 优先于白名单
+func (r *RateLimiter) WithDenyList(l *AccessList) *RateLimiter {
+	r.denyList = l
+	return r
+}
+
+// WithSubjectDimension 设置黑白名单匹配的主体维度名称(如 DimUser、DimIP), 默认 DimSubject; 其他维度的取值不参与名单匹配
+func (r *RateLimiter) WithSubjectDimension(name string) *RateLimiter {
+	r.subjectDim = name
+	return r
+}
+
+// WithAllowList 设置白名单, 见 RateLimiter.WithAllowList
+func (p *Policy) WithAllowList(l *AccessList) *Policy {
+	p.limiter.WithAllowList(l)
+	return p
+}
+
+// WithDenyList 设置黑名单, 见 RateLimiter.WithDenyList
+func (p *Policy) WithDenyList(l *AccessList) *Policy {
+	p.limiter.WithDenyList(l)
+	return p
+}
+
+// subjectDimension 返回黑白名单匹配的主体维度名称
+func (r *RateLimiter) subjectDimension() string {
+	if len(r.subjectDim) == 0 {
+		return DimSubject
+	}
+
+	return r.subjectDim
+}
+
+// validateAccessList 设置了黑白名单时, 存储Key需包含主体维度, 否则名单永远不会命中
+func (r *RateLimiter) validateAccessList(dimensions []KeyDimension) error {
+	if r.allowList == nil && r.denyList == nil {
+		return nil
+	}
+
+	if _, ok := dimensionValue(dimensions, r.subjectDimension()); !ok {
+		return fmt.Errorf("%w: allow/deny lists require subject dimension %q", ErrInvalidOptions, r.subjectDimension())
+	}
+
+	return nil
+}
+
+// applyAccessList 按黑白名单生成决策结果, 仅匹配主体维度的取值; 返回 false 表示主体不在名单中, 需由限流算法决策
+func (r *RateLimiter) applyAccessList(call limiterCall) (Decision, bool) {
+	if r.allowList == nil && r.denyList == nil {
+		return Decision{}, false
+	}
+
+	limit := call.options.limit(r.limiterType)
+	if r.denyList.Contains(call.subject) {
+		return Decision{Limit: limit, RetryAfter: -1, ResetAt: call.now, Reason: ReasonDenyList}, true
+	}
+	if r.allowList.Contains(call.subject) {
+		return Decision{Allowed: true, Limit: limit, Remaining: limit, ResetAt: call.now, Reason: ReasonAllowList}, true
+	}
+
+	return Decision{}, false
+}
+
+// dimensionValue 返回指定名称的维度取值
+func dimensionValue(dimensions []KeyDimension, name string) (string, bool) {
+	for _, dim := range dimensions {
+		if dim.Name == name {
+			return dim.Value, true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,96 @@
+// Copyright(C) 2024 Github Inc. All Rights Reserved.
+// Author: metrue8@gmail.com
+// Date:   2024/01/03
+
+package ratelimiter
+
+import (
+	"context"
+	"time"
+)
+
+// CalendarPeriod 日历配额的周期
+type CalendarPeriod int
+
+// 定义日历配额周期, 均按所在时区的日历对齐
+const (
+	PeriodHour  CalendarPeriod = iota + 1 // 自然小时, 夏令时回拨时重复的小时为两个周期
+	PeriodDay                             // 自然日, 夏令时切换当天为 23 或 25 小时
+	PeriodWeek                            // 自然周, 从周一 00:00 开始
+	PeriodMonth                           // 自然月, 从每月 1 日 00:00 开始
+)
+
+// String 返回周期名称
+func (p CalendarPeriod) String() string {
+	switch p {
+	case PeriodHour:
+		return "Hour"
+	case PeriodDay:
+		return "Day"
+	case PeriodWeek:
+		return "Week"
+	case PeriodMonth:
+		return "Month"
+	}
+
+	return "CalendarPeriod(unknown)"
+}
+
+// valid 是否为已知的周期
+func (p CalendarPeriod) valid() bool {
+	return p >= PeriodHour && p <= PeriodMonth
+}
+
+// calendarWindow 日历配额的一个周期, [start, end)
+type calendarWindow struct {
+	start time.Time // 周期开始时间
+	end   time.Time // 周期结束时间, 即下一个周期的开始时间
+}
+
+// window 返回 now 所在的周期
+//
+// 日、周、月以所在时区的日历日期计算边界, 由 time.Date 处理夏令时, 周期长度不固定;
+// 小时从 now 的绝对时间减去本地分秒, 夏令时回拨时重复的小时各自为独立的周期
+func (o calendarQuotaOptions) window(now time.Time) calendarWindow {
+	t := now.In(o.location)
+	y, m, d := t.Date()
+
+	var w calendarWindow
+	switch o.period {
+	case PeriodHour:
+		w.start = t.Add(-time.Duration(t.Minute())*time.Minute - time.Duration(t.Second())*time.Second - time.Duration(t.Nanosecond()))
+		w.end = w.start.Add(time.Hour)
+	case PeriodDay:
+		w.start = time.Date(y, m, d, 0, 0, 0, 0, o.location)
+		w.end = time.Date(y, m, d+1, 0, 0, 0, 0, o.location)
+	case PeriodWeek:
+		offset := (int(t.Weekday()) + 6) % 7 // 距离周一的天数
+		w.start = time.Date(y, m, d-offset, 0, 0, 0, 0, o.location)
+		w.end = time.Date(y, m, d-offset+7, 0, 0, 0, 0, o.location)
+	case PeriodMonth:
+		w.start = time.Date(y, m, 1, 0, 0, 0, 0, o.location)
+		w.end = time.Date(y, m+1, 1, 0, 0, 0, 0, o.location)
+	}
+
+	return w
+}
+
+// resetAfter 距离周期结束的时间, 单位毫秒, 至少为1
+func (w calendarWindow) resetAfter(now time.Time) int64 {
+	return maxInt64(1, w.end.Sub(now).Milliseconds())
+}
+
+// doCalendarQuotaLimiter 执行日历配额限流, Key 的过期时间为周期结束时间
+func (r *RateLimiter) doCalendarQuotaLimiter(ctx context.Context, call limiterCall, n int64) (Decision, error) {
+	options := []interface{}{
+		call.options.calendarQuotaOptions.limitCount,
+		call.options.calendarQuotaOptions.window(call.now).resetAfter(call.now),
+		n,
+	}
+	res, err := r.client.evalScript(ctx, "CalendarQuotaScript", []string{call.key}, options...)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	return parseDecision(res, call.now)
+}
@@ -0,0 +1,225 @@
+// Copyright(C) 2024 Github Inc. All Rights Reserved.
+// Author: metrue8@gmail.com
+// Date:   2024/01/03
+
+package ratelimiter
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Client 限流器客户端, 持有 Redis 实例、脚本Sha值、折叠代码标记、Key 前缀及限流记录通道
+//
+// 同一进程可创建多个 Client 分别对接不同的 Redis 部署, 互不影响
+type Client struct {
+	rdb          *redis.Client     // [V] Redis 客户端
+	compress     bool              // [-] 是否启用折叠代码, 默认不启用
+	keyPrefix    string            // [-] Redis Key 前缀, 默认 RedisKeyPrefix
+	recordBuffer int               // [-] 限流记录通道容量, 默认 defaultRecordBuffer
+	clock        func() time.Time  // [-] 当前时间获取函数, 默认 time.Now
+	instances    int64             // [-] 预期实例数, 本地限流时按此均分限流大小, 默认1
+	healthCheck  time.Duration     // [-] 本地限流期间 Redis 健康检查间隔, 默认 defaultHealthCheckInterval
+	recorder     *recorder         // [X] 限流记录通道                -- 内部创建
+	local        *localFallback    // [X] 本地限流器                  -- 内部创建, Redis 执行失败时启用
+	scripts      map[string]string // [X] 脚本名称 => 脚本内容         -- 按折叠代码标记选取
+	shas         map[string]string // [X] 脚本名称 => 脚本Sha值        -- 按折叠代码标记选取
+	roDisabled   int32             // [X] Redis 是否不支持只读脚本命令 -- 只读脚本返回未知命令时标记, 之后直接使用 EVALSHA
+}
+
+// ClientOption 限流器客户端参数设置函数
+type ClientOption func(c *Client)
+
+// WithCompress 设置是否启用折叠代码, 启用后执行去除注释及空白的脚本
+func WithCompress(compress bool) ClientOption {
+	return func(c *Client) {
+		c.compress = compress
+	}
+}
+
+// WithKeyPrefix 设置 Redis Key 前缀, 用于多个服务共用同一 Redis 时隔离限流数据
+func WithKeyPrefix(prefix string) ClientOption {
+	return func(c *Client) {
+		if len(prefix) > 0 {
+			c.keyPrefix = prefix
+		}
+	}
+}
+
+// WithRecordBuffer 设置限流记录通道容量, 通道满时丢弃记录
+func WithRecordBuffer(size int) ClientOption {
+	return func(c *Client) {
+		if size > 0 {
+			c.recordBuffer = size
+		}
+	}
+}
+
+// WithClock 设置当前时间获取函数, 限流脚本均以该时间计算窗口、令牌及漏水, 便于测试时模拟时间流逝
+func WithClock(clock func() time.Time) ClientOption {
+	return func(c *Client) {
+		if clock != nil {
+			c.clock = clock
+		}
+	}
+}
+
+// WithInstanceCount 设置预期实例数, Redis 不可用时各实例的本地限流器按此均分限流大小及速率
+func WithInstanceCount(n int64) ClientOption {
+	return func(c *Client) {
+		if n > 0 {
+			c.instances = n
+		}
+	}
+}
+
+// WithHealthCheckInterval 设置本地限流期间 Redis 健康检查间隔, 检查成功后切回 Redis 限流
+func WithHealthCheckInterval(interval time.Duration) ClientOption {
+	return func(c *Client) {
+		if interval > 0 {
+			c.healthCheck = interval
+		}
+	}
+}
+
+// withRecorder 使用已有的限流记录通道, 默认客户端与包级 RegisterHandler 共用同一通道
+func withRecorder(rec *recorder) ClientOption {
+	return func(c *Client) {
+		c.recorder = rec
+	}
+}
+
+// New 创建限流器客户端并预加载 Lua 脚本
+func New(rdb *redis.Client, opts ...ClientOption) *Client {
+	c := newClient(rdb, opts...)
+	if rdb != nil {
+		c.loadScripts(context.TODO())
+	}
+
+	return c
+}
+
+// newClient 创建限流器客户端, 不加载脚本
+func newClient(rdb *redis.Client, opts ...ClientOption) *Client {
+	c := &Client{
+		rdb:          rdb,
+		keyPrefix:    RedisKeyPrefix,
+		recordBuffer: defaultRecordBuffer,
+		clock:        time.Now,
+		instances:    1,
+		healthCheck:  defaultHealthCheckInterval,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.recorder == nil {
+		c.recorder = newRecorder(c.recordBuffer)
+	}
+	c.local = newLocalFallback(c)
+
+	c.scripts, c.shas = luaScriptMap, luaScriptShaMap
+	if c.compress {
+		c.scripts, c.shas = luaScriptOptMap, luaScriptOptShaMap
+	}
+
+	return c
+}
+
+// NewRateLimiter 创建使用该客户端的限流器
+func (c *Client) NewRateLimiter(product string, limiterType LimiterType, ops ...Options) *RateLimiter {
+	limiter := &RateLimiter{
+		ctx:         context.TODO(),
+		product:     product,
+		client:      c,
+		limiterType: limiterType,
+	}
+
+	if len(ops) > 0 {
+		limiter.options = ops[0]
+	}
+
+	return limiter
+}
+
+// RegisterHandler 注册该客户端的限流记录处理器
+func (c *Client) RegisterHandler(name string, handler RecordHandler) {
+	c.recorder.register(name, handler)
+}
+
+// UnregisterHandler 注销该客户端的限流记录处理器
+func (c *Client) UnregisterHandler(name string) {
+	c.recorder.unregister(name)
+}
+
+// Close 停止限流记录处理协程及本地限流的健康检查协程, 不会关闭 Redis 客户端; 关闭后的限流记录将被丢弃
+func (c *Client) Close() {
+	c.local.close()
+	c.recorder.close()
+}
+
+// now 返回客户端当前时间
+func (c *Client) now() time.Time {
+	return c.clock()
+}
+
+// ScriptShas 返回该客户端限流脚本的Sha值
+func (c *Client) ScriptShas() ScriptSha {
+	return ScriptSha{
+		FixedWindow: c.shas["FixedWindowScript"],
+		SlideWindow: c.shas["SlideWindowScript"],
+		TokenBucket: c.shas["TokenBucketScript"],
+		LeakyBucket: c.shas["LeakyBucketScript"],
+
+		CalendarQuota: c.shas["CalendarQuotaScript"],
+	}
+}
+
+// loadScripts 预加载该客户端的全部 Lua 脚本
+func (c *Client) loadScripts(ctx context.Context) {
+	loadScripts(ctx, c.rdb, c.scripts)
+}
+
+// loadScripts 预加载全部 Lua 脚本, 加载失败时在执行阶段通过 EVAL 重查
+func loadScripts(ctx context.Context, rdb *redis.Client, scripts map[string]string) {
+	for _, script := range scripts {
+		_, _ = LoadScript(ctx, rdb, script)
+	}
+}
+
+// script 按脚本名称获取脚本内容及Sha值
+func (c *Client) script(name string) (script, sha string) {
+	return c.scripts[name], c.shas[name]
+}
+
+// evalScript 按脚本名称执行脚本, 优先通过Sha值执行, 脚本缓存丢失时使用脚本重查; 执行失败时返回 ErrBackendUnavailable
+func (c *Client) evalScript(ctx context.Context, name string, keys []string, args ...interface{}) (interface{}, error) {
+	script, sha1 := c.script(name)
+	res, err := evalSha(ctx, c.rdb, sha1, keys, args...)
+	if isNoScriptErr(err) {
+		res, err = Eval(ctx, c.rdb, script, keys, args...)
+	}
+	return res, wrapBackendErr(err)
+}
+
+// evalScriptRO 按脚本名称执行只读脚本, 脚本缓存丢失时使用脚本重查; 执行失败时返回 ErrBackendUnavailable
+//
+// Redis 7.0 以下不支持 EVALSHA_RO/EVAL_RO, 首次返回未知命令后该客户端降级为 evalScript, 不影响其他客户端
+func (c *Client) evalScriptRO(ctx context.Context, name string, keys []string, args ...interface{}) (interface{}, error) {
+	if atomic.LoadInt32(&c.roDisabled) == 0 {
+		script, sha1 := c.script(name)
+		res, err := evalShaRO(ctx, c.rdb, sha1, keys, args...)
+		if isNoScriptErr(err) {
+			res, err = evalRO(ctx, c.rdb, script, keys, args...)
+		}
+		if !isUnknownCommandErr(err) {
+			return res, wrapBackendErr(err)
+		}
+		atomic.StoreInt32(&c.roDisabled, 1)
+	}
+
+	return c.evalScript(ctx, name, keys, args...)
+}
@@ -0,0 +1,231 @@
+// Copyright(C) 2024 Github Inc. All Rights Reserved.
+// Author: metrue8@gmail.com
+// Date:   2024/01/03
+
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cast"
+)
+
+// compositeRuleArgs 组合限流脚本中每条规则的参数个数: 限流器类型 + 6个参数
+const compositeRuleArgs = 7
+
+// Rule 组合限流规则
+type Rule struct {
+	Name    string       // [V] 规则名称, 拒绝时通过 CompositeDecision.RejectedBy 返回
+	Limiter *RateLimiter // [V] 规则对应的限流器, 使用其限流器类型、参数及存储Key
+}
+
+// RuleDecision 单条规则的决策结果
+type RuleDecision struct {
+	Name     string   // 规则名称
+	Decision Decision // 该规则单独校验的结果, 组合被拒绝时放行的规则也不会消耗许可
+}
+
+// CompositeDecision 组合限流决策结果
+type CompositeDecision struct {
+	Decision                  // 汇总结果: 放行时取剩余可用请求数最少的规则; 拒绝时取第一个拒绝的规则, 重试间隔取所有拒绝规则中最长的
+	RejectedBy string         // 第一个拒绝的规则名称, 放行时为空
+	Rules      []RuleDecision // 各规则的决策结果, 与规则顺序一致
+}
+
+// Composite 组合限流器, 在一次 Lua 脚本调用中同时校验多条规则(可为不同的限流器类型)
+//
+// 全部规则放行时才消耗许可, 任一规则拒绝时所有规则均不消耗; 仅支持 Allow/AllowN, 不支持预约
+// 使用 Redis 集群时各规则的 Key 需通过 Hash Tag 落在同一个分片
+// 组合限流只执行脚本, 按 FailError 处理 Redis 错误(返回 ErrBackendUnavailable), 规则限流器不能设置故障处理策略、试运行、黑白名单或惩罚策略
+type Composite struct {
+	client *Client // [X] 限流器客户端 -- 取自规则的限流器, 所有规则需使用同一个客户端
+	rules  []Rule  // [V] 组合规则
+}
+
+// NewComposite 创建组合限流器, 规则为空、名称为空或重复、限流器参数非法、客户端不一致时返回错误;
+// 规则限流器设置了组合限流无法生效的故障处理策略、试运行、黑白名单或惩罚策略时返回 ErrUnsupported, 避免设置被静默忽略
+func NewComposite(rules ...Rule) (*Composite, error) {
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("%w: composite requires at least one rule", ErrInvalidOptions)
+	}
+
+	names := make(map[string]struct{}, len(rules))
+	for i, rule := range rules {
+		if len(rule.Name) == 0 || rule.Limiter == nil {
+			return nil, fmt.Errorf("%w: composite rule %d requires name and limiter", ErrInvalidOptions, i)
+		}
+		if _, ok := names[rule.Name]; ok {
+			return nil, fmt.Errorf("%w: duplicate composite rule %q", ErrInvalidOptions, rule.Name)
+		}
+		names[rule.Name] = struct{}{}
+
+		if rule.Limiter.client != rules[0].Limiter.client {
+			return nil, fmt.Errorf("%w: composite rule %q uses a different client", ErrInvalidOptions, rule.Name)
+		}
+		if err := rule.Limiter.composable(); err != nil {
+			return nil, fmt.Errorf("composite rule %q: %w", rule.Name, err)
+		}
+		if err := rule.Limiter.Validate(); err != nil {
+			return nil, fmt.Errorf("composite rule %q: %w", rule.Name, err)
+		}
+	}
+
+	return &Composite{
+		client: rules[0].Limiter.client,
+		rules:  append([]Rule(nil), rules...),
+	}, nil
+}
+
+// Allow 消耗各规则的一个许可
+func (c *Composite) Allow(ctx context.Context) (CompositeDecision, error) {
+	return c.AllowN(ctx, 1)
+}
+
+// AllowN 一次性消耗各规则的 n 个许可, 任一规则许可不足时整体拒绝, 所有规则均不消耗
+func (c *Composite) AllowN(ctx context.Context, n int64) (ret CompositeDecision, err error) {
+	calls := make([]limiterCall, len(c.rules))
+	defer func() {
+		for i, rule := range c.rules {
+			record := LimiterRecord{
+				Type:      rule.Limiter.limiterType,
+				Key:       calls[i].key,
+				Timestamp: c.client.now(),
+				Error:     err,
+			}
+			if i < len(ret.Rules) {
+				// 记录实际是否消耗了该规则的许可
+				record.Result = ret.Rules[i].Decision
+				record.Result.Allowed = ret.Allowed
+			}
+			c.client.recorder.send(record)
+		}
+	}()
+
+	if n <= 0 {
+		return CompositeDecision{}, invalidPermitsErr(n)
+	}
+
+	now := c.client.now()
+	keys := make([]string, 0, len(c.rules))
+	seen := make(map[string]string, len(c.rules))
+	args := make([]interface{}, 0, 2+len(c.rules)*compositeRuleArgs)
+	args = append(args, n, now.UnixMilli())
+	for i, rule := range c.rules {
+		if calls[i], err = rule.Limiter.newCall(now); err != nil {
+			return CompositeDecision{}, fmt.Errorf("composite rule %q: %w", rule.Name, err)
+		}
+
+		// 同一个 Key 在脚本中会被重复校验但只按各自规则写入, 无法保证全部规则成立
+		if name, ok := seen[calls[i].key]; ok {
+			return CompositeDecision{}, fmt.Errorf("%w: composite rules %q and %q share key %q", ErrInvalidOptions, name, rule.Name, calls[i].key)
+		}
+		seen[calls[i].key] = rule.Name
+
+		keys = append(keys, calls[i].key)
+		args = append(args, compositeArgs(rule.Limiter.limiterType, calls[i])...)
+	}
+
+	res, err := c.client.evalScript(ctx, "CompositeScript", keys, args...)
+	if err != nil {
+		return CompositeDecision{}, err
+	}
+
+	return c.parseDecision(res, now)
+}
+
+// Reset 清除所有规则在 Redis 中的全部状态
+func (c *Composite) Reset(ctx context.Context) error {
+	for _, rule := range c.rules {
+		if err := rule.Limiter.Reset(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// composable 校验限流器能否作为组合限流规则, 组合限流不经过 executeKey, 以下设置均无法生效
+func (r *RateLimiter) composable() error {
+	switch {
+	case r.failurePolicy != FailError:
+		return fmt.Errorf("%w: composite does not support failure policy %s", ErrUnsupported, r.failurePolicy)
+	case r.dryRun:
+		return fmt.Errorf("%w: composite does not support dry run", ErrUnsupported)
+	case r.allowList != nil || r.denyList != nil:
+		return fmt.Errorf("%w: composite does not support allow/deny lists", ErrUnsupported)
+	case r.penalty != nil:
+		return fmt.Errorf("%w: composite does not support penalty", ErrUnsupported)
+	}
+
+	return nil
+}
+
+// compositeArgs 生成组合限流脚本中单条规则的参数, 不足6个参数时补0
+func compositeArgs(limiterType LimiterType, call limiterCall) []interface{} {
+	opts := call.options
+	args := make([]interface{}, 0, compositeRuleArgs)
+	args = append(args, string(limiterType))
+
+	switch limiterType {
+	case FixedWindowType:
+		args = append(args, opts.fixedWindowOptions.limitCount, opts.fixedWindowOptions.unitTime, opts.fixedWindowOptions.expiration)
+	case SlideWindowType:
+		args = append(args, opts.slideWindowOptions.limitCount, opts.slideWindowOptions.unitTime, opts.slideWindowOptions.expiration)
+	case TokenBucketType:
+		intervalPerPermit, resetBucketInterval, initTokens := tokenBucketParams(opts.tokenBucketOptions)
+		args = append(args, intervalPerPermit, opts.tokenBucketOptions.maxTokens, resetBucketInterval, initTokens, opts.tokenBucketOptions.expiration)
+	case LeakyBucketType:
+		args = append(args, opts.leakyBucketOptions.capacity, opts.leakyBucketOptions.leakRate, opts.leakyBucketOptions.expiration)
+	case CalendarQuotaType:
+		args = append(args, opts.calendarQuotaOptions.limitCount, opts.calendarQuotaOptions.window(call.now).resetAfter(call.now))
+	}
+
+	for len(args) < compositeRuleArgs {
+		args = append(args, 0)
+	}
+
+	return args
+}
+
+// parseDecision 将组合限流脚本返回的数组转换为组合决策结果
+//
+// 脚本返回: {是否放行, 第一个拒绝的规则序号, 各规则的5个决策字段...}
+func (c *Composite) parseDecision(res interface{}, now time.Time) (CompositeDecision, error) {
+	values, ok := res.([]interface{})
+	if !ok || len(values) != 2+len(c.rules)*decisionFieldCount {
+		return CompositeDecision{}, fmt.Errorf("%w: unexpected script result: %v", ErrBackendUnavailable, res)
+	}
+
+	ret := CompositeDecision{Rules: make([]RuleDecision, 0, len(c.rules))}
+	ret.Allowed = cast.ToInt64(values[0]) == 1
+	rejected := cast.ToInt(values[1])
+
+	for i, rule := range c.rules {
+		offset := 2 + i*decisionFieldCount
+		d, err := parseDecision(values[offset:offset+decisionFieldCount], now)
+		if err != nil {
+			return CompositeDecision{}, err
+		}
+		ret.Rules = append(ret.Rules, RuleDecision{Name: rule.Name, Decision: d})
+
+		if d.ResetAt.After(ret.ResetAt) {
+			ret.ResetAt = d.ResetAt
+		}
+		if ret.Allowed && (i == 0 || d.Remaining < ret.Remaining) {
+			ret.Limit, ret.Remaining = d.Limit, d.Remaining
+		}
+		if !d.Allowed && ret.RetryAfter >= 0 && (d.RetryAfter < 0 || d.RetryAfter > ret.RetryAfter) {
+			ret.RetryAfter = d.RetryAfter
+		}
+	}
+
+	if !ret.Allowed && rejected > 0 && rejected <= len(c.rules) {
+		ret.RejectedBy = c.rules[rejected-1].Name
+		ret.Limit = ret.Rules[rejected-1].Decision.Limit
+		ret.Remaining = ret.Rules[rejected-1].Decision.Remaining
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,88 @@
+// Copyright(C) 2024 Github Inc. All Rights Reserved.
+// Author: metrue8@gmail.com
+// Date:   2024/01/03
+
+package ratelimiter
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cast"
+)
+
+// Decision 限流决策结果, 各类型限流器统一输出
+type Decision struct {
+	Allowed    bool           // 本次请求是否放行
+	Limit      int64          // 限流大小(窗口限制数/令牌桶上限/漏桶容量)
+	Remaining  int64          // 本次请求之后剩余可用请求数
+	RetryAfter time.Duration  // 被拒绝时距离下一个可用许可的时间, 小于0表示永远无法满足
+	ResetAt    time.Time      // 限流状态完全恢复(窗口重置/桶满/桶空)的时间
+	Fallback   FailurePolicy  // Redis 不可用时所采用的故障处理策略, 为 FailError 表示由 Redis 决策
+	Reason     DecisionReason // 决策依据, 命中黑白名单或惩罚策略时不为 ReasonLimiter
+	Key        string         // 本次放行实际扣减的存储Key, 供 Refund 归还; 未扣减 Redis 状态(被拒绝、名单、降级、试运行)时为空
+
+	cost int64 // 本次放行扣减且尚未归还的许可数, Refund 归还的数量不超过该值
+}
+
+// DecisionReason 限流决策依据
+type DecisionReason int
+
+// 定义限流决策依据
+const (
+	ReasonLimiter   DecisionReason = iota // 由限流算法决策, 默认依据
+	ReasonAllowList                       // 主体在白名单中, 直接放行, 不执行 Lua 脚本
+	ReasonDenyList                        // 主体在黑名单中, 直接拒绝, 不执行 Lua 脚本
+	ReasonPenalty                         // 主体处于封禁期或本次触发封禁, 见 Penalty
+)
+
+// String 返回决策依据名称
+func (r DecisionReason) String() string {
+	switch r {
+	case ReasonLimiter:
+		return "Limiter"
+	case ReasonAllowList:
+		return "AllowList"
+	case ReasonDenyList:
+		return "DenyList"
+	case ReasonPenalty:
+		return "Penalty"
+	}
+
+	return "DecisionReason(unknown)"
+}
+
+// 定义 Lua 脚本返回结果的下标
+const (
+	decisionAllowed    = iota // 是否放行, 1 放行 0 拒绝
+	decisionLimit             // 限流大小
+	decisionRemaining         // 剩余可用请求数
+	decisionRetryAfter        // 重试间隔, 单位毫秒
+	decisionResetAfter        // 距离状态恢复的时间, 单位毫秒
+	decisionFieldCount
+)
+
+// parseDecision 将 Lua 脚本返回的数组转换为限流决策结果
+func parseDecision(res interface{}, now time.Time) (Decision, error) {
+	values, ok := res.([]interface{})
+	if !ok || len(values) < decisionFieldCount {
+		return Decision{}, fmt.Errorf("%w: unexpected script result: %v", ErrBackendUnavailable, res)
+	}
+
+	return Decision{
+		Allowed:    cast.ToInt64(values[decisionAllowed]) == 1,
+		Limit:      cast.ToInt64(values[decisionLimit]),
+		Remaining:  cast.ToInt64(values[decisionRemaining]),
+		RetryAfter: time.Duration(cast.ToInt64(values[decisionRetryAfter])) * time.Millisecond,
+		ResetAt:    now.Add(time.Duration(cast.ToInt64(values[decisionResetAfter])) * time.Millisecond),
+	}, nil
+}
+
+// Err 被拒绝时返回 ErrLimited, 放行时返回 nil, 便于调用方统一按错误处理
+func (d Decision) Err() error {
+	if d.Allowed {
+		return nil
+	}
+
+	return ErrLimited
+}
@@ -0,0 +1,69 @@
+// Copyright(C) 2024 Github Inc. All Rights Reserved.
+// Author: metrue8@gmail.com
+// Date:   2024/01/03
+
+package ratelimiter
+
+import (
+	"errors"
+	"fmt"
+)
+
+// 定义限流器错误类型, 均可通过 errors.Is 判断
+var (
+	ErrLimited            = errors.New("ratelimiter: rate limited")            // 请求被限流
+	ErrBackendUnavailable = errors.New("ratelimiter: backend unavailable")     // Redis 执行失败
+	ErrInvalidOptions     = errors.New("ratelimiter: invalid options")         // 限流器参数或调用参数非法
+	ErrUnknownLimiterType = errors.New("ratelimiter: unknown limiter type")    // 未知的限流器类型
+	ErrUnsupported        = errors.New("ratelimiter: operation not supported") // 限流器类型不支持该操作
+	ErrInvalidKey         = errors.New("ratelimiter: invalid key")             // Key 格式无法解析
+)
+
+// backendError Redis 执行错误, 同时匹配 ErrBackendUnavailable 与原始错误(如 context.DeadlineExceeded)
+type backendError struct {
+	err error
+}
+
+// Error 实现 error 接口
+func (e *backendError) Error() string {
+	return ErrBackendUnavailable.Error() + ": " + e.err.Error()
+}
+
+// Is 支持 errors.Is(err, ErrBackendUnavailable)
+func (e *backendError) Is(target error) bool {
+	return target == ErrBackendUnavailable
+}
+
+// Unwrap 返回原始错误
+func (e *backendError) Unwrap() error {
+	return e.err
+}
+
+// wrapBackendErr 将 Redis 返回的错误包装为 ErrBackendUnavailable
+func wrapBackendErr(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &backendError{err: err}
+}
+
+// invalidPermitsErr 许可数非法错误
+func invalidPermitsErr(n int64) error {
+	return fmt.Errorf("%w: invalid permits count %d, must be positive", ErrInvalidOptions, n)
+}
+
+// invalidOptionErr 限流器参数非法错误
+func invalidOptionErr(limiterType LimiterType, format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s %s", ErrInvalidOptions, limiterType, fmt.Sprintf(format, args...))
+}
+
+// unknownTypeErr 未知限流器类型错误
+func unknownTypeErr(limiterType LimiterType) error {
+	return fmt.Errorf("%w: %q", ErrUnknownLimiterType, limiterType)
+}
+
+// invalidKeyErr Key 格式错误
+func invalidKeyErr(key, reason string) error {
+	return fmt.Errorf("%w: %q %s", ErrInvalidKey, key, reason)
+}
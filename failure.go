@@ -0,0 +1,80 @@
+// Copyright(C) 2024 Github Inc. All Rights Reserved.
+// Author: metrue8@gmail.com
+// Date:   2024/01/03
+
+package ratelimiter
+
+import (
+	"errors"
+	"time"
+)
+
+// FailurePolicy Redis 执行失败(ErrBackendUnavailable)时的处理策略
+type FailurePolicy int
+
+// 定义故障处理策略
+const (
+	FailError     FailurePolicy = iota // 返回 ErrBackendUnavailable, 由调用方自行处理, 默认策略
+	FailOpen                           // 放行请求, 不返回错误
+	FailClosed                         // 拒绝请求, 不返回错误
+	FallbackLocal                      // 启用进程内本地限流器决策, 不返回错误; 限流大小按 WithInstanceCount 均分, Redis 恢复后自动切回
+)
+
+// failClosedRetryAfter Redis 不可用时拒绝请求的重试间隔, 避免 Wait 在故障期间频繁重试
+const failClosedRetryAfter = time.Second
+
+// String 返回故障处理策略名称
+func (p FailurePolicy) String() string {
+	switch p {
+	case FailError:
+		return "FailError"
+	case FailOpen:
+		return "FailOpen"
+	case FailClosed:
+		return "FailClosed"
+	case FallbackLocal:
+		return "FallbackLocal"
+	}
+
+	return "FailurePolicy(unknown)"
+}
+
+// WithFailurePolicy 设置 Redis 执行失败时的处理策略
+//
+// 策略生效时 Do/AllowN 返回的决策结果及限流记录中 Fallback 为所采用的策略, 限流记录的 Error 仍为原始错误
+func (r *RateLimiter) WithFailurePolicy(policy FailurePolicy) *RateLimiter {
+	r.failurePolicy = policy
+	return r
+}
+
+// WithFailurePolicy 设置 Redis 执行失败时的处理策略, 见 RateLimiter.WithFailurePolicy
+func (p *Policy) WithFailurePolicy(policy FailurePolicy) *Policy {
+	p.limiter.WithFailurePolicy(policy)
+	return p
+}
+
+// applyFailurePolicy Redis 执行失败时按故障处理策略生成决策结果, 返回 false 表示不处理该错误
+func (r *RateLimiter) applyFailurePolicy(call limiterCall, n int64, err error) (Decision, bool) {
+	if r.failurePolicy == FailError || !errors.Is(err, ErrBackendUnavailable) {
+		return Decision{}, false
+	}
+
+	if r.failurePolicy == FallbackLocal {
+		r.client.local.activate(err)
+		return r.client.local.allow(r.limiterType, call.baseKey, call.options, call.now, n), true
+	}
+
+	d := Decision{
+		Limit:    call.options.limit(r.limiterType),
+		ResetAt:  call.now,
+		Fallback: r.failurePolicy,
+	}
+	switch r.failurePolicy {
+	case FailClosed:
+		d.RetryAfter = failClosedRetryAfter
+	default:
+		d.Allowed = true
+	}
+
+	return d, true
+}
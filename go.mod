@@ -5,6 +5,7 @@ go 1.17
 require (
 	github.com/redis/go-redis/v9 v9.3.1
 	github.com/spf13/cast v1.6.0
+	github.com/stretchr/testify v1.10.0
 )
 
 require (
@@ -12,6 +13,5 @@ require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/stretchr/testify v1.10.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
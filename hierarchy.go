@@ -0,0 +1,141 @@
+// Copyright(C) 2024 Github Inc. All Rights Reserved.
+// Author: metrue8@gmail.com
+// Date:   2024/01/03
+
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+)
+
+// Level 分级配额中的一级, 如 组织 > 项目 > 用户
+type Level struct {
+	Name        string      // [V] 层级名称, 同时作为存储Key的维度名称, 如 "org"
+	LimiterType LimiterType // [V] 该层级的限流器类型
+	Options     []Option    // [V] 该层级的限流器参数
+}
+
+// hierarchyLevel 已校验参数的层级
+type hierarchyLevel struct {
+	name    string       // 层级名称
+	limiter *RateLimiter // 层级限流器模板, 按请求路径追加维度
+}
+
+// Hierarchy 分级配额限流器, 请求按路径(如 组织ID、项目ID、用户ID)依次消耗每一级的配额
+//
+// 各层级可使用不同的限流器类型及参数, 下级的存储Key包含上级的路径, 如 org=o1::project=p1::user=u1;
+// 所有层级在一次 Lua 脚本调用中校验并消耗(见 Composite), 任一层级拒绝时均不消耗;
+// 与组合限流一致, Redis 错误返回 ErrBackendUnavailable, 不支持故障处理策略、试运行、黑白名单及惩罚策略
+type Hierarchy struct {
+	client  *Client          // [V] 限流器客户端
+	product string           // [V] 业务线
+	levels  []hierarchyLevel // [V] 从上到下的层级
+}
+
+// NewHierarchy 使用默认客户端创建分级配额限流器
+func NewHierarchy(product string, levels ...Level) (*Hierarchy, error) {
+	return defaultClient.NewHierarchy(product, levels...)
+}
+
+// NewHierarchy 创建分级配额限流器, 层级为空、名称为空或重复、参数非法时返回错误
+func (c *Client) NewHierarchy(product string, levels ...Level) (*Hierarchy, error) {
+	if len(levels) == 0 {
+		return nil, fmt.Errorf("%w: hierarchy requires at least one level", ErrInvalidOptions)
+	}
+
+	h := &Hierarchy{
+		client:  c,
+		product: product,
+		levels:  make([]hierarchyLevel, 0, len(levels)),
+	}
+	names := make(map[string]struct{}, len(levels))
+	for _, level := range levels {
+		if len(level.Name) == 0 {
+			return nil, fmt.Errorf("%w: hierarchy level requires name", ErrInvalidOptions)
+		}
+		if _, ok := names[level.Name]; ok {
+			return nil, fmt.Errorf("%w: duplicate hierarchy level %q", ErrInvalidOptions, level.Name)
+		}
+		names[level.Name] = struct{}{}
+
+		limiter, err := c.NewLimiter(product, level.LimiterType, level.Options...)
+		if err != nil {
+			return nil, fmt.Errorf("hierarchy level %q: %w", level.Name, err)
+		}
+		h.levels = append(h.levels, hierarchyLevel{name: level.Name, limiter: limiter})
+	}
+
+	return h, nil
+}
+
+// Allow 按路径消耗每一级的一个许可, path 依次为各层级的取值
+func (h *Hierarchy) Allow(ctx context.Context, path ...string) (CompositeDecision, error) {
+	return h.AllowN(ctx, 1, path...)
+}
+
+// AllowN 按路径一次性消耗每一级的 n 个许可, 任一层级许可不足时整体拒绝
+//
+// 返回结果的 Limit/Remaining 取剩余可用请求数最少(最严格)的层级, RejectedBy 为第一个拒绝的层级名称
+func (h *Hierarchy) AllowN(ctx context.Context, n int64, path ...string) (CompositeDecision, error) {
+	if len(path) != len(h.levels) {
+		return CompositeDecision{}, fmt.Errorf("%w: hierarchy path has %d values, want %d", ErrInvalidOptions, len(path), len(h.levels))
+	}
+
+	limiters, err := h.pathLimiters(path)
+	if err != nil {
+		return CompositeDecision{}, err
+	}
+
+	composite := &Composite{client: h.client, rules: make([]Rule, 0, len(limiters))}
+	for i, limiter := range limiters {
+		composite.rules = append(composite.rules, Rule{Name: h.levels[i].name, Limiter: limiter})
+	}
+
+	ret, err := composite.AllowN(ctx, n)
+	if err != nil {
+		return ret, err
+	}
+
+	// 拒绝时同样返回最严格层级的剩余可用请求数
+	for i, rule := range ret.Rules {
+		if i == 0 || rule.Decision.Remaining < ret.Remaining {
+			ret.Limit, ret.Remaining = rule.Decision.Limit, rule.Decision.Remaining
+		}
+	}
+
+	return ret, nil
+}
+
+// Limiter 返回路径最后一级对应的限流器, 如 Limiter("o1") 为组织级、Limiter("o1", "p1") 为项目级; 用于 Inspect/Reset 等操作
+func (h *Hierarchy) Limiter(path ...string) (*RateLimiter, error) {
+	if len(path) == 0 || len(path) > len(h.levels) {
+		return nil, fmt.Errorf("%w: hierarchy path has %d values, want 1 to %d", ErrInvalidOptions, len(path), len(h.levels))
+	}
+
+	limiters, err := h.pathLimiters(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return limiters[len(limiters)-1], nil
+}
+
+// pathLimiters 按路径生成前 len(path) 级的限流器, 每一级的维度包含上级的路径
+func (h *Hierarchy) pathLimiters(path []string) ([]*RateLimiter, error) {
+	dimensions := make([]KeyDimension, 0, len(path))
+	limiters := make([]*RateLimiter, 0, len(path))
+	for i, value := range path {
+		level := h.levels[i]
+		if len(value) == 0 {
+			return nil, fmt.Errorf("%w: empty value for hierarchy level %q", ErrInvalidOptions, level.name)
+		}
+		dimensions = append(dimensions, KeyDimension{Name: level.name, Value: value})
+
+		limiter := *level.limiter
+		limiter.dimensions = dimensions[: i+1 : i+1]
+		limiters = append(limiters, &limiter)
+	}
+
+	return limiters, nil
+}
@@ -5,20 +5,14 @@
 package ratelimiter
 
 import (
-	"context"
-	"sync"
-
 	"github.com/redis/go-redis/v9"
 )
 
-// ScriptShas 定义存储Sha值全局变量
+// ScriptShas 定义存储Sha值全局变量, 对应默认客户端
 var ScriptShas *ScriptSha
 
-// redisClient 存储 Redis 资源实例
-var redisClient *redis.Client
-
-// compressFlag 定义是否启用折叠代码标记
-var compressFlag bool
+// defaultClient 默认客户端, 由 Init 设置, 供包级 NewRateLimiter/ResetProduct 使用
+var defaultClient = newClient(nil, withRecorder(defaultRecorder))
 
 // ScriptSha 定义存储Load脚本后的Sha值结构体
 type ScriptSha struct {
@@ -26,37 +20,20 @@ type ScriptSha struct {
 	SlideWindow string
 	TokenBucket string
 	LeakyBucket string
+
+	CalendarQuota string
 }
 
-// Init  初始化配置
+// Init  初始化默认客户端配置, 需在创建限流器之前调用
 func Init(client *redis.Client, compress bool) {
-	// 设置Redis实例
-	redisClient = client
-
-	// 设置折叠代码标记
-	compressFlag = compress
+	// 设置Redis实例及折叠代码标记, 启动时加载Lua脚本
+	defaultClient = New(client, WithCompress(compress), withRecorder(defaultRecorder))
 
-	// 启动时加载Lua脚本
-	loadRedisScript(client)
+	shas := defaultClient.ScriptShas()
+	ScriptShas = &shas
 }
 
-// loadRedisScript 预加载Lua脚本
-func loadRedisScript(client *redis.Client) {
-	var onece sync.Once
-	onece.Do(func() {
-		ctx := context.TODO()
-		ScriptShas = &ScriptSha{}
-		if res, err := LoadScript(ctx, client, getLuaScript(FixedWindowType, compressFlag)); err == nil {
-			ScriptShas.FixedWindow = res
-		}
-		if res, err := LoadScript(ctx, client, getLuaScript(SlideWindowType, compressFlag)); err == nil {
-			ScriptShas.SlideWindow = res
-		}
-		if res, err := LoadScript(ctx, client, getLuaScript(TokenBucketType, compressFlag)); err == nil {
-			ScriptShas.TokenBucket = res
-		}
-		if res, err := LoadScript(ctx, client, getLuaScript(LeakyBucketType, compressFlag)); err == nil {
-			ScriptShas.LeakyBucket = res
-		}
-	})
+// NewRateLimiter 使用默认客户端实例化限流器
+func NewRateLimiter(product string, limiterType LimiterType, ops ...Options) *RateLimiter {
+	return defaultClient.NewRateLimiter(product, limiterType, ops...)
 }
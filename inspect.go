@@ -0,0 +1,118 @@
+// Copyright(C) 2024 Github Inc. All Rights Reserved.
+// Author: metrue8@gmail.com
+// Date:   2024/01/03
+
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cast"
+)
+
+// LimiterState 限流器当前状态, 由只读脚本查询获得, 查询不会消耗许可
+type LimiterState struct {
+	Limit      int64     // 限流大小(窗口限制数/令牌桶上限/漏桶容量)
+	Count      int64     // 当前已占用数量(窗口已用请求数/已消耗令牌数/桶中水量)
+	Remaining  int64     // 剩余可用请求数
+	LastRefill time.Time // 最近一次补充的时间(固定窗口开始/令牌填充/漏水), 滑动窗口及无状态时为零值
+	ResetAt    time.Time // 限流状态完全恢复(窗口重置/桶满/桶空)的时间
+}
+
+// Inspect 查询限流器当前状态, 不消耗许可
+//
+// 各限流器均使用只读脚本(不执行 INCR/HINCRBY/HSET 等写命令), Redis 7.0 及以上通过 EVALSHA_RO 执行, 可路由到从库
+//
+// 限流大小超过 MaxBucketCapacity 时存储Key按纳秒随机分散到多个分片(Key 的最后一段), 各分片独立计数;
+// 此时 Inspect 只查询其中一个随机分片, 返回的是该分片的状态而非所有分片的汇总
+func (r *RateLimiter) Inspect(ctx context.Context) (LimiterState, error) {
+	call, err := r.newCall(r.client.now())
+	if err != nil {
+		return LimiterState{}, err
+	}
+
+	var (
+		name string
+		args []interface{}
+	)
+	switch r.limiterType {
+	case FixedWindowType:
+		name = "FixedWindowInspectScript"
+		args = []interface{}{
+			call.options.fixedWindowOptions.limitCount,
+			call.options.fixedWindowOptions.unitTime,
+			call.now.UnixMilli(),
+		}
+	case SlideWindowType:
+		name = "SlideWindowInspectScript"
+		args = []interface{}{
+			call.options.slideWindowOptions.limitCount,
+			call.now.UnixMilli(),
+			call.options.slideWindowOptions.unitTime,
+		}
+	case TokenBucketType:
+		name = "TokenBucketInspectScript"
+		intervalPerPermit, resetBucketInterval, initTokens := tokenBucketParams(call.options.tokenBucketOptions)
+		args = []interface{}{
+			intervalPerPermit,
+			call.now.UnixMilli(),
+			call.options.tokenBucketOptions.maxTokens,
+			resetBucketInterval,
+			initTokens,
+		}
+	case LeakyBucketType:
+		name = "LeakyBucketInspectScript"
+		args = []interface{}{
+			call.options.leakyBucketOptions.capacity,
+			call.options.leakyBucketOptions.leakRate,
+			call.now.UnixMilli(),
+		}
+	case CalendarQuotaType:
+		// 计数结构与固定窗口一致, 以周期长度为窗口、距离周期开始的时间为当前时间, 脚本返回的剩余时间即距离周期结束的时间
+		name = "FixedWindowInspectScript"
+		window := call.options.calendarQuotaOptions.window(call.now)
+		args = []interface{}{
+			call.options.calendarQuotaOptions.limitCount,
+			window.end.Sub(window.start).Milliseconds(),
+			call.now.Sub(window.start).Milliseconds(),
+		}
+	default:
+		return LimiterState{}, unknownTypeErr(r.limiterType)
+	}
+
+	res, err := r.client.evalScriptRO(ctx, name, []string{call.key}, args...)
+	if err != nil {
+		return LimiterState{}, err
+	}
+
+	state, err := parseLimiterState(res, call.now)
+	if err == nil && r.limiterType == CalendarQuotaType {
+		state.LastRefill = call.options.calendarQuotaOptions.window(call.now).start
+	}
+
+	return state, err
+}
+
+// parseLimiterState 将只读脚本返回的数组转换为限流器状态
+//
+// 脚本返回: {限流大小, 已占用数量, 剩余可用请求数, 最近补充时间(ms), 距离状态恢复时间(ms)}
+func parseLimiterState(res interface{}, now time.Time) (LimiterState, error) {
+	values, ok := res.([]interface{})
+	if !ok || len(values) < 5 {
+		return LimiterState{}, fmt.Errorf("%w: unexpected script result: %v", ErrBackendUnavailable, res)
+	}
+
+	state := LimiterState{
+		Limit:     cast.ToInt64(values[0]),
+		Count:     cast.ToInt64(values[1]),
+		Remaining: cast.ToInt64(values[2]),
+		ResetAt:   now.Add(time.Duration(cast.ToInt64(values[4])) * time.Millisecond),
+	}
+	if lastRefill := cast.ToInt64(values[3]); lastRefill > 0 {
+		state.LastRefill = time.UnixMilli(lastRefill)
+	}
+
+	return state, nil
+}
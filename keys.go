@@ -0,0 +1,204 @@
+// Copyright(C) 2024 Github Inc. All Rights Reserved.
+// Author: metrue8@gmail.com
+// Date:   2024/01/03
+
+package ratelimiter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// keySeparator Redis Key 各部分的分隔符
+const keySeparator = "::"
+
+// 常用的限流维度名称
+const (
+	DimUser    = "user"    // 用户
+	DimIP      = "ip"      // 客户端IP
+	DimRoute   = "route"   // 接口路由
+	DimTenant  = "tenant"  // 租户
+	DimSubject = "subject" // 限流主体, Policy 默认使用的维度
+)
+
+var (
+	// keyEscaper 转义 Key 各部分中的分隔符及维度名值分隔符, 转义后各部分不含 ":" 与 "="
+	keyEscaper = strings.NewReplacer("%", "%25", ":", "%3A", "=", "%3D")
+	// keyUnescaper 还原 keyEscaper 转义的内容
+	keyUnescaper = strings.NewReplacer("%3A", ":", "%3D", "=", "%25", "%")
+)
+
+// KeyDimension 限流 Key 的命名维度, 如 user=1001
+type KeyDimension struct {
+	Name  string // 维度名称
+	Value string // 维度取值
+}
+
+// KeyBuilder 限流 Key 构造器, 按 前缀::限流器类型::业务线::维度名=维度值... 的格式组合 Key
+//
+// 各部分中的 ":"、"=" 及 "%" 均会转义, 业务线或维度值带 "::" 时也能通过 ParseKey 准确还原;
+// 维度按添加顺序排列, 顺序不同视为不同的 Key. 构造器的方法均返回副本, 可在多个协程间共用同一个基础构造器
+type KeyBuilder struct {
+	prefix      string         // [-] Key 前缀, 默认 RedisKeyPrefix
+	limiterType LimiterType    // [V] 限流器类型
+	product     string         // [V] 业务线
+	dimensions  []KeyDimension // [-] 有序维度
+}
+
+// KeyParts ParseKey 解析得到的 Key 各部分
+type KeyParts struct {
+	Prefix     string         // Key 前缀
+	Type       LimiterType    // 限流器类型
+	Product    string         // 业务线
+	Dimensions []KeyDimension // 有序维度
+	Window     int64          // 固定窗口序号(窗口开始时间 / 窗口大小), 日历配额为周期开始时间(ms), 其他类型或无后缀时为0
+	Shard      int64          // 大容量限流的分片序号, 无后缀时为0
+	HasSuffix  bool           // 是否带有 genLimiterKey 生成的窗口/分片后缀
+}
+
+// NewKeyBuilder 创建使用默认前缀的 Key 构造器
+func NewKeyBuilder(limiterType LimiterType, product string) KeyBuilder {
+	return KeyBuilder{
+		prefix:      RedisKeyPrefix,
+		limiterType: limiterType,
+		product:     product,
+	}
+}
+
+// KeyBuilder 创建使用该客户端 Key 前缀的 Key 构造器
+func (c *Client) KeyBuilder(limiterType LimiterType, product string) KeyBuilder {
+	return NewKeyBuilder(limiterType, product).WithPrefix(c.keyPrefix)
+}
+
+// WithPrefix 设置 Key 前缀, 为空时保持不变
+func (b KeyBuilder) WithPrefix(prefix string) KeyBuilder {
+	if len(prefix) > 0 {
+		b.prefix = prefix
+	}
+
+	return b
+}
+
+// With 追加命名维度, 维度名称已存在时在原位置替换取值
+func (b KeyBuilder) With(name, value string) KeyBuilder {
+	dimensions := make([]KeyDimension, 0, len(b.dimensions)+1)
+	replaced := false
+	for _, dim := range b.dimensions {
+		if dim.Name == name {
+			dim.Value = value
+			replaced = true
+		}
+		dimensions = append(dimensions, dim)
+	}
+	if !replaced {
+		dimensions = append(dimensions, KeyDimension{Name: name, Value: value})
+	}
+
+	b.dimensions = dimensions
+	return b
+}
+
+// User 追加用户维度
+func (b KeyBuilder) User(id string) KeyBuilder {
+	return b.With(DimUser, id)
+}
+
+// IP 追加客户端IP维度
+func (b KeyBuilder) IP(ip string) KeyBuilder {
+	return b.With(DimIP, ip)
+}
+
+// Route 追加接口路由维度
+func (b KeyBuilder) Route(route string) KeyBuilder {
+	return b.With(DimRoute, route)
+}
+
+// Tenant 追加租户维度
+func (b KeyBuilder) Tenant(tenant string) KeyBuilder {
+	return b.With(DimTenant, tenant)
+}
+
+// Dimensions 返回有序维度的副本
+func (b KeyBuilder) Dimensions() []KeyDimension {
+	return append([]KeyDimension(nil), b.dimensions...)
+}
+
+// Build 组合 Key, 不含 genLimiterKey 追加的窗口/分片后缀; 可直接用于 WithRedisKey
+func (b KeyBuilder) Build() string {
+	var sb strings.Builder
+	sb.WriteString(keyEscaper.Replace(b.prefix))
+	sb.WriteString(keySeparator)
+	sb.WriteString(string(b.limiterType))
+	sb.WriteString(keySeparator)
+	sb.WriteString(keyEscaper.Replace(b.product))
+	for _, dim := range b.dimensions {
+		sb.WriteString(keySeparator)
+		sb.WriteString(keyEscaper.Replace(dim.Name))
+		sb.WriteString("=")
+		sb.WriteString(keyEscaper.Replace(dim.Value))
+	}
+
+	return sb.String()
+}
+
+// String 实现 fmt.Stringer 接口
+func (b KeyBuilder) String() string {
+	return b.Build()
+}
+
+// ParseKey 将 KeyBuilder 或限流器生成的 Key 解析为各部分, 便于管理工具按业务线及维度展示限流状态; 格式不符时返回 ErrInvalidKey
+func ParseKey(key string) (KeyParts, error) {
+	parts := strings.Split(key, keySeparator)
+	if len(parts) < 3 {
+		return KeyParts{}, invalidKeyErr(key, "too few parts")
+	}
+
+	p := KeyParts{
+		Prefix:  keyUnescaper.Replace(parts[0]),
+		Type:    LimiterType(parts[1]),
+		Product: keyUnescaper.Replace(parts[2]),
+	}
+	if !p.Type.valid() {
+		return KeyParts{}, invalidKeyErr(key, fmt.Sprintf("unknown limiter type %q", parts[1]))
+	}
+
+	rest := parts[3:]
+	for len(rest) > 0 && strings.Contains(rest[0], "=") {
+		nv := strings.SplitN(rest[0], "=", 2)
+		p.Dimensions = append(p.Dimensions, KeyDimension{
+			Name:  keyUnescaper.Replace(nv[0]),
+			Value: keyUnescaper.Replace(nv[1]),
+		})
+		rest = rest[1:]
+	}
+	if len(rest) == 0 {
+		return p, nil
+	}
+
+	// 固定窗口及日历配额的后缀为 "窗口::分片", 其余为 "分片"
+	want := 1
+	if p.Type.windowed() {
+		want = 2
+	}
+	if len(rest) != want {
+		return KeyParts{}, invalidKeyErr(key, "unexpected suffix")
+	}
+
+	nums := make([]int64, 0, len(rest))
+	for _, part := range rest {
+		n, err := strconv.ParseInt(part, 10, 64)
+		if err != nil || n < 0 {
+			return KeyParts{}, invalidKeyErr(key, fmt.Sprintf("invalid suffix %q", part))
+		}
+		nums = append(nums, n)
+	}
+
+	p.HasSuffix = true
+	p.Shard = nums[len(nums)-1]
+	if p.Type.windowed() {
+		p.Window = nums[0]
+	}
+
+	return p, nil
+}
@@ -6,11 +6,9 @@ package ratelimiter
 
 import (
 	"context"
-	"log"
 	"math"
 	"time"
 
-	"github.com/redis/go-redis/v9"
 	"github.com/spf13/cast"
 )
 
@@ -31,18 +29,72 @@ const (
 	SlideWindowType LimiterType = "SlideWindow" // 滑动窗口限流器
 	TokenBucketType LimiterType = "TokenBucket" // 令牌桶限流器
 	LeakyBucketType LimiterType = "LeakyBucket" // 漏桶限流器
+
+	CalendarQuotaType LimiterType = "CalendarQuota" // 日历配额限流器, 按时区的自然小时/日/周/月对齐
 )
 
+// valid 是否为已知的限流器类型
+func (t LimiterType) valid() bool {
+	switch t {
+	case FixedWindowType, SlideWindowType, TokenBucketType, LeakyBucketType, CalendarQuotaType:
+		return true
+	}
+
+	return false
+}
+
+// windowed 存储Key是否带有窗口后缀(固定窗口序号/日历周期开始时间)
+func (t LimiterType) windowed() bool {
+	return t == FixedWindowType || t == CalendarQuotaType
+}
+
+// Limiter 限流器接口, 业务代码依赖该接口时单元测试可使用 NoopLimiter/DenyAllLimiter 替代, 无需连接 Redis
+type Limiter interface {
+	// Allow 消耗一个许可
+	Allow(ctx context.Context) (Decision, error)
+	// AllowN 一次性消耗 n 个许可, 许可不足时整体拒绝
+	AllowN(ctx context.Context, n int64) (Decision, error)
+	// Wait 阻塞等待直到获取一个许可
+	Wait(ctx context.Context) error
+	// WaitN 阻塞等待直到获取 n 个许可
+	WaitN(ctx context.Context, n int64) error
+	// Inspect 查询当前状态, 不消耗许可
+	Inspect(ctx context.Context) (LimiterState, error)
+	// Reset 清除全部限流状态
+	Reset(ctx context.Context) error
+}
+
+// 各类型限流器均通过 RateLimiter 实现 Limiter 接口
+var _ Limiter = (*RateLimiter)(nil)
+
 // RateLimiter 定义限流器结构体
+//
+// 限流器在启动时配置一次后即可复用, 并发调用 Do/AllowN 等方法是安全的: 每次调用独立计算当前时间、分片后缀及存储Key, 不修改限流器本身;
+// 所有 With 方法均直接修改限流器, 应在共享给其他协程前完成配置, 不能与执行并发
 type RateLimiter struct {
-	ctx         context.Context // [V] 上下文
-	product     string          // [V] 业务线
-	client      *redis.Client   // [V] Redis 客户端
-	limiterType LimiterType     // [V] 限流器类型
-	redisKey    string          // [X] 存储Key                    -- 内部计算获得
-	currentTime time.Time       // [X] 当前时间, 单位毫秒           -- 程序内获取
-	options     Options         // [-] 限流器参数
-	optionFuncs []OptionFunc    // [-] 自定义拓展函数
+	ctx           context.Context // [V] 上下文
+	product       string          // [V] 业务线
+	client        *Client         // [V] 限流器客户端
+	limiterType   LimiterType     // [V] 限流器类型
+	customKey     string          // [-] 自定义存储Key               -- 参数传入
+	dimensions    []KeyDimension  // [-] 存储Key的有序维度           -- WithDimension 传入
+	failurePolicy FailurePolicy   // [-] Redis 执行失败时的处理策略  -- WithFailurePolicy 传入, 默认 FailError
+	dryRun        bool            // [-] 是否试运行                  -- WithDryRun 传入, 默认不启用
+	allowList     *AccessList     // [-] 白名单                      -- WithAllowList 传入
+	denyList      *AccessList     // [-] 黑名单                      -- WithDenyList 传入
+	subjectDim    string          // [-] 黑白名单匹配的主体维度名称  -- WithSubjectDimension 传入, 默认 DimSubject
+	penalty       *Penalty        // [-] 惩罚策略                    -- WithPenalty 传入
+	options       Options         // [-] 限流器参数
+	optionFuncs   []OptionFunc    // [-] 自定义拓展函数
+}
+
+// limiterCall 单次调用的执行参数, 每次调用独立生成, 保证限流器可被并发复用
+type limiterCall struct {
+	key     string    // 存储Key                 -- 按当前时间计算获得
+	baseKey string    // 不含窗口/分片后缀的存储Key -- 本地限流时作为状态Key
+	now     time.Time // 当前时间                -- 程序内获取
+	options Options   // 补全默认值后的限流器参数
+	subject string    // 黑白名单匹配的主体维度取值, 存储Key不含主体维度时为空
 }
 
 // Option 限流器参数
@@ -51,97 +103,143 @@ type Options struct {
 	slideWindowOptions slideWindowOptions // 滑动窗口限流器选项
 	tokenBucketOptions tokenBucketOptions // 令牌桶限流器选项
 	leakyBucketOptions leakyBucketOptions // 漏桶限流器选项
+
+	calendarQuotaOptions calendarQuotaOptions // 日历配额限流器选项
 }
 
 // fixedWindowOptions 固定窗口限流器选项结构体
 type fixedWindowOptions struct {
 	limitCount int64 // [V] 限流大小                    -- 参数传入
-	unitTime   int64 // [V] 时间窗口大小, 单位秒，默认1秒  -- 参数传入
-	expiration int64 // [-] Key 过期时间, 单位秒         -- 内部计算获得
+	unitTime   int64 // [V] 时间窗口大小, 单位毫秒       -- 参数传入
+	expiration int64 // [-] Key 过期时间, 单位毫秒       -- WithTTL 传入或内部计算获得
 }
 
 // slideWindowOptions 滑动窗口限流器选项结构体
 type slideWindowOptions struct {
 	limitCount int64 // [V] 限流大小                    -- 参数传入
-	unitTime   int64 // [V] 时间窗口大小, 单位秒，默认1秒  -- 参数传入
-	expiration int64 // [-] Key 过期时间, 单位秒         -- 内部计算获得
+	unitTime   int64 // [V] 时间窗口大小, 单位毫秒       -- 参数传入
+	expiration int64 // [-] Key 过期时间, 单位毫秒       -- WithTTL 传入或内部计算获得
 }
 
 // tokenBucketOptions 令牌桶限流器选项结构体
 type tokenBucketOptions struct {
-	maxTokens    int64 // [V] 令牌桶的上限                    -- 参数传入
-	initTokens   int64 // [V] 令牌桶初始Token数量              -- 参数传入
-	timeInterval int64 // [V] 桶生成时间间隔，单位秒，默认1秒    -- 参数传入
+	maxTokens      int64   // [V] 令牌桶的上限                    -- 参数传入
+	initTokens     int64   // [V] 令牌桶初始Token数量              -- 参数传入
+	timeInterval   int64   // [V] 桶生成时间间隔, 单位毫秒          -- 参数传入
+	permitInterval float64 // [-] 令牌的产生间隔, 单位毫秒, 可为小数   -- WithRate 传入, 设置后忽略 timeInterval
+	expiration     int64   // [-] Key 过期时间, 单位毫秒           -- WithTTL 传入, 默认重置间隔的10倍
 }
 
 // leakyBucketOptions 漏桶限流器选项结构体
 type leakyBucketOptions struct {
-	leakRate   int64 // [V] 漏水速率                    -- 参数传入
-	capacity   int64 // [V] 桶的容量                    -- 参数传入
-	expiration int64 // [-] Key 过期时间, 单位秒         -- 内部计算获得
+	leakRate   float64 // [V] 漏水速率, 每秒漏出的请求数, 可为小数 -- 参数传入
+	capacity   int64   // [V] 桶的容量                    -- 参数传入
+	expiration int64   // [-] Key 过期时间, 单位毫秒       -- WithTTL 传入或内部计算获得
+}
+
+// calendarQuotaOptions 日历配额限流器选项结构体
+type calendarQuotaOptions struct {
+	limitCount int64          // [V] 每个周期的限流大小       -- 参数传入
+	period     CalendarPeriod // [V] 周期                   -- 参数传入
+	location   *time.Location // [-] 周期对齐的时区          -- WithLocation 传入, 默认 UTC
 }
 
 type OptionFunc func(svr *RateLimiter)
 
-// NewFixedWindowOption 固定窗口限流器参数设置
-func NewFixedWindowOption(limitCount, unitTime int64) Options {
-	return Options{
-		fixedWindowOptions: fixedWindowOptions{
-			limitCount: limitCount,
-			unitTime:   unitTime,
-		},
+// validate 校验固定窗口限流器参数
+func (o fixedWindowOptions) validate() error {
+	if o.limitCount <= 0 {
+		return invalidOptionErr(FixedWindowType, "limitCount must be positive, got %d", o.limitCount)
 	}
+	if o.unitTime <= 0 {
+		return invalidOptionErr(FixedWindowType, "unitTime must be positive, got %d", o.unitTime)
+	}
+
+	return nil
 }
 
-// NewSlideWindowOption 滑动窗口限流器参数设置
-func NewSlideWindowOption(limitCount, unitTime int64) Options {
-	// 返回一个包含fixedWindowOptions结构体的Options结构体
-	return Options{
-		fixedWindowOptions: fixedWindowOptions{
-			// 设置限流计数
-			limitCount: limitCount,
-			// 设置时间单位
-			unitTime: unitTime,
-		},
+// validate 校验滑动窗口限流器参数
+func (o slideWindowOptions) validate() error {
+	if o.limitCount <= 0 {
+		return invalidOptionErr(SlideWindowType, "limitCount must be positive, got %d", o.limitCount)
+	}
+	if o.unitTime <= 0 {
+		return invalidOptionErr(SlideWindowType, "unitTime must be positive, got %d", o.unitTime)
 	}
+
+	return nil
 }
 
-// NewTokenBucketOption 令牌桶限流器参数设置
-func NewTokenBucketOption(maxTokens, timeInterval, initTokens int64) Options {
-	return Options{
-		tokenBucketOptions: tokenBucketOptions{
-			maxTokens:    maxTokens,
-			initTokens:   initTokens,
-			timeInterval: timeInterval,
-		},
+// validate 校验令牌桶限流器参数
+func (o tokenBucketOptions) validate() error {
+	if o.maxTokens <= 0 {
+		return invalidOptionErr(TokenBucketType, "maxTokens must be positive, got %d", o.maxTokens)
 	}
+	if o.permitInterval <= 0 && o.timeInterval <= 0 {
+		return invalidOptionErr(TokenBucketType, "timeInterval must be positive, got %d", o.timeInterval)
+	}
+	if o.initTokens < 0 {
+		return invalidOptionErr(TokenBucketType, "initTokens must not be negative, got %d", o.initTokens)
+	}
+
+	return nil
 }
 
-// NewLeakyBucketOption 漏桶限流器参数设置
-func NewLeakyBucketOption(capacity, leakRate int64) Options {
-	return Options{
-		leakyBucketOptions: leakyBucketOptions{
-			leakRate: leakRate,
-			capacity: capacity,
-		},
+// validate 校验漏桶限流器参数
+func (o leakyBucketOptions) validate() error {
+	if o.capacity <= 0 {
+		return invalidOptionErr(LeakyBucketType, "capacity must be positive, got %d", o.capacity)
+	}
+	if o.leakRate <= 0 {
+		return invalidOptionErr(LeakyBucketType, "leakRate must be positive, got %v", o.leakRate)
 	}
+
+	return nil
 }
 
-// NewRateLimiter 限流器实例化
-func NewRateLimiter(product string, limiterType LimiterType, ops ...Options) *RateLimiter {
-	limiter := &RateLimiter{
-		ctx:         context.TODO(),
-		product:     product,
-		client:      redisClient,
-		limiterType: limiterType,
-		currentTime: time.Now(), // 默认当前时间
+// validate 校验日历配额限流器参数
+func (o calendarQuotaOptions) validate() error {
+	if o.limitCount <= 0 {
+		return invalidOptionErr(CalendarQuotaType, "limitCount must be positive, got %d", o.limitCount)
 	}
-
-	if len(ops) > 0 {
-		limiter.options = ops[0]
+	if !o.period.valid() {
+		return invalidOptionErr(CalendarQuotaType, "unknown period %d", o.period)
 	}
 
-	return limiter
+	return nil
+}
+
+// NewFixedWindowOption 固定窗口限流器参数设置, 等价于 WithLimit(limitCount) + WithWindow(unitTime 秒)
+func NewFixedWindowOption(limitCount, unitTime int64) Options {
+	// 整数秒窗口不会产生参数组合错误, 取值范围在执行时校验
+	o, _ := buildOptions(FixedWindowType, WithLimit(limitCount), WithWindow(time.Duration(unitTime)*time.Second))
+	return o
+}
+
+// NewSlideWindowOption 滑动窗口限流器参数设置, 等价于 WithLimit(limitCount) + WithWindow(unitTime 秒)
+func NewSlideWindowOption(limitCount, unitTime int64) Options {
+	o, _ := buildOptions(SlideWindowType, WithLimit(limitCount), WithWindow(time.Duration(unitTime)*time.Second))
+	return o
+}
+
+// NewTokenBucketOption 令牌桶限流器参数设置, 等价于 WithLimit(maxTokens) + WithWindow(timeInterval 秒), 初始令牌数为 initTokens
+func NewTokenBucketOption(maxTokens, timeInterval, initTokens int64) Options {
+	o, _ := buildOptions(TokenBucketType, WithLimit(maxTokens), WithWindow(time.Duration(timeInterval)*time.Second), withInitTokens(initTokens))
+	return o
+}
+
+// NewLeakyBucketOption 漏桶限流器参数设置, 等价于 WithLimit(capacity) + WithRate(leakRate, time.Second)
+func NewLeakyBucketOption(capacity, leakRate int64) Options {
+	// 漏水速率非法时保留原值, 在执行时校验
+	o, _ := buildOptions(LeakyBucketType, WithLimit(capacity), WithRate(leakRate, time.Second))
+	o.leakyBucketOptions.leakRate = float64(leakRate)
+	return o
+}
+
+// NewCalendarQuotaOption 日历配额限流器参数设置, 等价于 WithLimit(limitCount) + WithPeriod(period) + WithLocation(loc), loc 为 nil 时使用 UTC
+func NewCalendarQuotaOption(limitCount int64, period CalendarPeriod, loc *time.Location) Options {
+	o, _ := buildOptions(CalendarQuotaType, WithLimit(limitCount), WithPeriod(period), WithLocation(loc))
+	return o
 }
 
 // WithContext 上下文设置
@@ -167,89 +265,270 @@ func (r *RateLimiter) WithOption(opt Options) *RateLimiter {
 // WithRedisKey 支持自定义设置RedisKey
 func (r *RateLimiter) WithRedisKey(key string) *RateLimiter {
 	if len(key) > 0 {
-		r.redisKey = key
+		r.customKey = key
 	}
 
 	return r
 }
 
-// initOptions 初始化限流器参数
-func (r *RateLimiter) initOptions(opt Options) error {
-	switch r.limiterType {
+// WithDimension 追加存储Key的命名维度(如 DimUser), 同一业务线下按维度取值分别限流; 维度名称已存在时替换取值
+func (r *RateLimiter) WithDimension(name, value string) *RateLimiter {
+	r.dimensions = r.keyBuilder().With(name, value).Dimensions()
+	return r
+}
+
+// WithDryRun 设置是否试运行, 用于上线新的限流规则前观察其影响
+//
+// 试运行时照常执行 Lua 脚本(放行的请求同样消耗许可), 限流记录标记 DryRun 并保留实际决策, 但调用方始终得到放行结果
+func (r *RateLimiter) WithDryRun(dryRun bool) *RateLimiter {
+	r.dryRun = dryRun
+	return r
+}
+
+// keyBuilder 返回限流器存储Key的构造器, 不含窗口/分片后缀
+func (r *RateLimiter) keyBuilder() KeyBuilder {
+	b := r.client.KeyBuilder(r.limiterType, r.product)
+	b.dimensions = r.dimensions
+	return b
+}
+
+// newCall 按指定时间生成单次调用的执行参数, 不修改限流器本身
+func (r *RateLimiter) newCall(now time.Time) (limiterCall, error) {
+	b := r.keyBuilder()
+	if !r.limiterType.valid() {
+		return limiterCall{}, unknownTypeErr(r.limiterType)
+	}
+
+	opts, err := r.resolveOptions()
+	if err != nil {
+		return limiterCall{}, err
+	}
+
+	if err := r.validateAccessList(b.dimensions); err != nil {
+		return limiterCall{}, err
+	}
+
+	call := limiterCall{
+		now:     now,
+		options: opts,
+	}
+	call.subject, _ = dimensionValue(b.dimensions, r.subjectDimension())
+
+	// 用户自定义 RedisKey 优先级最高, 否则按当前时间生成(固定窗口的 Key 随窗口滚动)
+	call.key, call.baseKey = r.customKey, r.customKey
+	if len(call.key) == 0 {
+		call.baseKey = b.Build()
+		call.key = r.genLimiterKey(call.baseKey, call.options, now)
+	}
+
+	return call, nil
+}
+
+// Validate 校验限流器配置, 便于在启动时提前发现配置错误; 执行时也会校验, 非法配置返回 ErrInvalidOptions
+func (r *RateLimiter) Validate() error {
+	if !r.limiterType.valid() {
+		return unknownTypeErr(r.limiterType)
+	}
+
+	if _, err := r.resolveOptions(); err != nil {
+		return err
+	}
+
+	if r.penalty != nil {
+		if _, err := r.penalty.validate(); err != nil {
+			return err
+		}
+	}
+
+	return r.validateAccessList(r.dimensions)
+}
+
+// resolveOptions 校验限流器参数, 返回补全默认值后的参数副本
+func (r *RateLimiter) resolveOptions() (Options, error) {
+	return r.options.resolve(r.limiterType)
+}
+
+// resolve 按限流器类型校验参数, 返回补全默认值后的参数副本
+func (o Options) resolve(limiterType LimiterType) (Options, error) {
+	var opts Options
+	switch limiterType {
 	case FixedWindowType:
-		r.options.fixedWindowOptions = opt.fixedWindowOptions
-		if r.options.fixedWindowOptions.expiration == 0 {
-			// 默认过期时间设置为5分钟, 防止并发过高导致RedisKey被频繁删除
-			r.options.fixedWindowOptions.expiration = 300
+		opts.fixedWindowOptions = o.fixedWindowOptions
+		if err := opts.fixedWindowOptions.validate(); err != nil {
+			return opts, err
+		}
+		if opts.fixedWindowOptions.expiration == 0 {
+			// 默认过期时间设置为5分钟, 防止并发过高导致RedisKey被频繁删除; 窗口更长时至少覆盖整个窗口
+			opts.fixedWindowOptions.expiration = maxInt64(300000, opts.fixedWindowOptions.unitTime)
 		}
 	case SlideWindowType:
-		r.options.slideWindowOptions = opt.slideWindowOptions
-		if r.options.slideWindowOptions.expiration == 0 {
-			// 当key过期时会存在瞬时并发的情况, 因此过期时间不能太短或者改用定时清除
-			if r.options.slideWindowOptions.expiration <= 3600 {
-				r.options.slideWindowOptions.expiration = 3600
-			} else if r.options.slideWindowOptions.expiration <= 14400 {
-				r.options.slideWindowOptions.expiration = r.options.slideWindowOptions.unitTime * 2
-			}
+		opts.slideWindowOptions = o.slideWindowOptions
+		if err := opts.slideWindowOptions.validate(); err != nil {
+			return opts, err
+		}
+		if opts.slideWindowOptions.expiration == 0 {
+			// 每次放行都会续期, 保留两个窗口即可覆盖窗口内的全部小格子
+			opts.slideWindowOptions.expiration = opts.slideWindowOptions.unitTime * 2
 		}
 	case TokenBucketType:
-		r.options.tokenBucketOptions = opt.tokenBucketOptions
+		opts.tokenBucketOptions = o.tokenBucketOptions
+		if err := opts.tokenBucketOptions.validate(); err != nil {
+			return opts, err
+		}
+		if opts.tokenBucketOptions.expiration == 0 {
+			// 初始化令牌桶的过期时间, 设置为重置间隔的 10 倍
+			_, resetBucketInterval, _ := tokenBucketParams(opts.tokenBucketOptions)
+			opts.tokenBucketOptions.expiration = cast.ToInt64(math.Ceil(resetBucketInterval * 10))
+		}
 	case LeakyBucketType:
-		r.options.leakyBucketOptions = opt.leakyBucketOptions
-		if r.options.leakyBucketOptions.expiration == 0 {
-			if r.options.leakyBucketOptions.expiration >= 14400 {
-				r.options.leakyBucketOptions.expiration = 14400
-			} else if r.options.leakyBucketOptions.expiration <= 3600 {
-				r.options.leakyBucketOptions.expiration = 3600
-			}
+		opts.leakyBucketOptions = o.leakyBucketOptions
+		if err := opts.leakyBucketOptions.validate(); err != nil {
+			return opts, err
+		}
+		if opts.leakyBucketOptions.expiration == 0 {
+			// 桶中水量最多为两倍容量(预约排队), 漏空之后状态不再有意义
+			drain := cast.ToInt64(math.Ceil(float64(opts.leakyBucketOptions.capacity) * 1000 / opts.leakyBucketOptions.leakRate))
+			opts.leakyBucketOptions.expiration = drain * 2
+		}
+	case CalendarQuotaType:
+		opts.calendarQuotaOptions = o.calendarQuotaOptions
+		if err := opts.calendarQuotaOptions.validate(); err != nil {
+			return opts, err
+		}
+		// 各实例须使用同一时区才能对齐周期, 默认 UTC 而非本机时区
+		if opts.calendarQuotaOptions.location == nil {
+			opts.calendarQuotaOptions.location = time.UTC
 		}
 	}
 
-	// 用户自定义 RedisKey 优先级最高
-	if len(r.redisKey) == 0 {
-		r.redisKey = r.genLimiterKey()
+	return opts, nil
+}
+
+// limit 返回对应限流器类型的限流大小(窗口限制数/令牌桶上限/漏桶容量)
+func (o Options) limit(limiterType LimiterType) int64 {
+	switch limiterType {
+	case FixedWindowType:
+		return o.fixedWindowOptions.limitCount
+	case SlideWindowType:
+		return o.slideWindowOptions.limitCount
+	case TokenBucketType:
+		return o.tokenBucketOptions.maxTokens
+	case LeakyBucketType:
+		return o.leakyBucketOptions.capacity
+	case CalendarQuotaType:
+		return o.calendarQuotaOptions.limitCount
 	}
 
-	return nil
+	return 0
+}
+
+// maxInt64 返回两者中的较大值
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+
+	return b
 }
 
-// GetRedisKey 输出RedisKey
+// GetRedisKey 输出按当前时间计算的RedisKey, 大容量限流时分片后缀随机选取
 func (r *RateLimiter) GetRedisKey() string {
-	return r.redisKey
+	call, _ := r.newCall(r.client.now())
+	return call.key
+}
+
+// Do 执行限流器, 返回统一的限流决策结果
+func (r *RateLimiter) Do() (Decision, error) {
+	return r.AllowN(r.ctx, 1)
+}
+
+// Allow 执行限流器并消耗一个许可, 与 Do 相同但使用调用方的上下文
+func (r *RateLimiter) Allow(ctx context.Context) (Decision, error) {
+	return r.AllowN(ctx, 1)
+}
+
+// AllowN 执行限流器并一次性消耗 n 个许可, 许可不足时整体拒绝, 不会部分消耗
+func (r *RateLimiter) AllowN(ctx context.Context, n int64) (Decision, error) {
+	ret, _, err := r.execute(ctx, n, false)
+	return ret, err
+}
+
+// execute 执行限流器, reserve 为 true 时以预约模式执行(仅令牌桶与漏桶支持)
+func (r *RateLimiter) execute(ctx context.Context, n int64, reserve bool) (Decision, limiterCall, error) {
+	return r.executeCall(ctx, n, reserve, r.newCall)
 }
 
-// Do 执行限流器
-func (r *RateLimiter) Do() (ret int64, err error) {
+// executeCall 按 newCall 生成的执行参数执行限流器, 供 Policy 按主体生成存储Key
+func (r *RateLimiter) executeCall(ctx context.Context, n int64, reserve bool, newCall func(now time.Time) (limiterCall, error)) (ret Decision, call limiterCall, err error) {
+	// 按故障处理策略决策时, 限流记录仍保留原始错误; 试运行时限流记录保留实际决策
+	var (
+		backendErr error
+		actual     *Decision
+	)
 	defer func() {
 		record := LimiterRecord{
 			Type:      r.limiterType,
-			Key:       r.redisKey,
+			Key:       call.key,
 			Result:    ret,
-			Timestamp: time.Now(),
+			Timestamp: r.client.now(),
 			Error:     err,
+			Fallback:  ret.Fallback,
+			DryRun:    r.dryRun,
 		}
-		select {
-		case recordChan <- record:
-			// 成功发送到通道
-		default:
-			// 通道已满，记录丢弃事件
-			log.Printf("Warning: Record channel full, dropping record for key: %s", r.redisKey)
+		if backendErr != nil {
+			record.Error = backendErr
 		}
+		if actual != nil {
+			record.Result = *actual
+		}
+		r.client.recorder.send(record)
 	}()
 
-	if err := r.initOptions(r.options); err != nil {
-		return 0, err
+	if n <= 0 {
+		return Decision{}, call, invalidPermitsErr(n)
 	}
 
-	switch r.limiterType {
-	case FixedWindowType:
-		ret, err = r.doFixedWindowLimiter()
-	case SlideWindowType:
-		ret, err = r.doSlideWindowLimiter()
-	case TokenBucketType:
-		ret, err = r.doTokenBucketLimiter()
-	case LeakyBucketType:
-		ret, err = r.doLeakyBucketLimiter()
+	// 每次执行都以当前时间计算, 保证阻塞等待后重试时窗口与令牌能够正确滚动
+	if call, err = newCall(r.client.now()); err != nil {
+		return Decision{}, call, err
+	}
+
+	// 黑白名单优先于限流算法; 本地限流启用期间不再访问 Redis, 由健康检查协程负责切回
+	listed, ok := r.applyAccessList(call)
+	switch {
+	case ok:
+		ret = listed
+	case r.failurePolicy == FallbackLocal && r.client.local.isActive():
+		ret = r.client.local.allow(r.limiterType, call.baseKey, call.options, call.now, n)
+	case r.penalty != nil && !reserve:
+		ret, err = r.doPenaltyLimiter(ctx, call, n)
+	case r.limiterType == FixedWindowType:
+		ret, err = r.doFixedWindowLimiter(ctx, call, n)
+	case r.limiterType == SlideWindowType:
+		ret, err = r.doSlideWindowLimiter(ctx, call, n)
+	case r.limiterType == TokenBucketType:
+		ret, err = r.doTokenBucketLimiter(ctx, call, n, reserve)
+	case r.limiterType == LeakyBucketType:
+		ret, err = r.doLeakyBucketLimiter(ctx, call, n, reserve)
+	case r.limiterType == CalendarQuotaType:
+		ret, err = r.doCalendarQuotaLimiter(ctx, call, n)
+	default:
+		err = unknownTypeErr(r.limiterType)
+	}
+
+	if d, ok := r.applyFailurePolicy(call, n, err); ok {
+		ret, backendErr, err = d, err, nil
+	}
+
+	// 仅由 Redis 限流算法放行时才实际扣减了存储Key
+	if err == nil && ret.Allowed && ret.Reason == ReasonLimiter && ret.Fallback == FailError && !r.dryRun {
+		ret.Key, ret.cost = call.key, n
+	}
+
+	if r.dryRun && err == nil {
+		d := ret
+		actual = &d
+		ret.Allowed, ret.RetryAfter = true, 0
 	}
 
 	// 执行自定义拓展函数
@@ -257,141 +536,113 @@ func (r *RateLimiter) Do() (ret int64, err error) {
 		fn(r)
 	}
 
-	return ret, err
+	return ret, call, err
 }
 
 // doFixedWindowLimiter 执行固定窗口限流
-func (r *RateLimiter) doFixedWindowLimiter() (int64, error) {
+func (r *RateLimiter) doFixedWindowLimiter(ctx context.Context, call limiterCall, n int64) (Decision, error) {
 	options := []interface{}{
-		r.options.fixedWindowOptions.limitCount,
-		r.options.fixedWindowOptions.unitTime,
-		r.options.fixedWindowOptions.expiration,
+		call.options.fixedWindowOptions.limitCount,
+		call.options.fixedWindowOptions.unitTime,
+		call.options.fixedWindowOptions.expiration,
+		call.now.UnixMilli(),
+		n,
 	}
-	res, err := EvalSha(r.ctx, r.client, r.getScriptSha(), []string{r.redisKey}, options...)
-
-	// 脚本缓存丢失时执行一次使用脚本重查
-	if err != nil && err.Error() == NoScriptMsg {
-		res, err = Eval(r.ctx, r.client, r.getScript(), []string{r.redisKey}, options...)
-	}
-
+	res, err := r.client.evalScript(ctx, "FixedWindowScript", []string{call.key}, options...)
 	if err != nil {
-		return 0, err
+		return Decision{}, err
 	}
 
-	return cast.ToInt64(res), nil
+	return parseDecision(res, call.now)
 }
 
 // doSlideWindowLimiter 执行滑动窗口限流
-func (r *RateLimiter) doSlideWindowLimiter() (int64, error) {
+func (r *RateLimiter) doSlideWindowLimiter(ctx context.Context, call limiterCall, n int64) (Decision, error) {
 	options := []interface{}{
-		r.options.slideWindowOptions.limitCount,
-		r.currentTime.UnixMilli(),
-		r.options.slideWindowOptions.unitTime,
-		r.options.slideWindowOptions.expiration,
+		call.options.slideWindowOptions.limitCount,
+		call.now.UnixMilli(),
+		call.options.slideWindowOptions.unitTime,
+		call.options.slideWindowOptions.expiration,
+		n,
 	}
-	res, err := EvalSha(r.ctx, r.client, r.getScriptSha(), []string{r.redisKey}, options...)
-
-	// 脚本缓存丢失时执行一次使用脚本重查
-	if err != nil && err.Error() == NoScriptMsg {
-		res, err = Eval(r.ctx, r.client, r.getScript(), []string{r.redisKey}, options...)
-	}
-
+	res, err := r.client.evalScript(ctx, "SlideWindowScript", []string{call.key}, options...)
 	if err != nil {
-		return 0, err
+		return Decision{}, err
 	}
 
-	return cast.ToInt64(res), nil
+	return parseDecision(res, call.now)
 }
 
 // doTokenBucketLimiter 执行令牌桶限流
-func (r *RateLimiter) doTokenBucketLimiter() (int64, error) {
+func (r *RateLimiter) doTokenBucketLimiter(ctx context.Context, call limiterCall, n int64, reserve bool) (Decision, error) {
 	// 最大令牌数   -- 对应限流大小
-	bucketMaxTokens := r.options.tokenBucketOptions.maxTokens
-	// 限流时间间隔 -- 对应时间窗口
-	resetBucketInterval := cast.ToInt64(r.options.tokenBucketOptions.timeInterval * 1000)
-	// 令牌的产生间隔 = 限流时间 / 最大令牌数
-	intervalPerPermit := int64(1)
-	if resetBucketInterval > bucketMaxTokens {
-		intervalPerPermit = cast.ToInt64(math.Ceil(float64(resetBucketInterval) / float64(bucketMaxTokens)))
-	}
-	// 初始令牌数
-	initTokens := r.options.tokenBucketOptions.initTokens
-	// 用 最大的突发流量的持续时间 计算的结果更加合理,并不是每次初始化都要将桶装满
-	if initTokens > bucketMaxTokens {
-		initTokens = bucketMaxTokens
-	}
+	bucketMaxTokens := call.options.tokenBucketOptions.maxTokens
+	intervalPerPermit, resetBucketInterval, initTokens := tokenBucketParams(call.options.tokenBucketOptions)
 
 	options := []interface{}{
 		intervalPerPermit,
-		r.currentTime.UnixMilli(),
+		call.now.UnixMilli(),
 		bucketMaxTokens,
 		resetBucketInterval,
 		initTokens,
+		n,
+		cast.ToInt(reserve),
+		call.options.tokenBucketOptions.expiration,
 	}
-	res, err := EvalSha(r.ctx, r.client, r.getScriptSha(), []string{r.redisKey}, options...)
-
-	// 脚本缓存丢失时执行一次使用脚本重查
-	if err != nil && err.Error() == NoScriptMsg {
-		res, err = Eval(r.ctx, r.client, r.getScript(), []string{r.redisKey}, options...)
-	}
-
+	res, err := r.client.evalScript(ctx, "TokenBucketScript", []string{call.key}, options...)
 	if err != nil {
-		return 0, err
+		return Decision{}, err
 	}
 
-	return cast.ToInt64(res), nil
+	return parseDecision(res, call.now)
 }
 
-// doLeakyBucketLimiter 执行漏桶限流
-func (r *RateLimiter) doLeakyBucketLimiter() (int64, error) {
-	options := []interface{}{
-		r.options.leakyBucketOptions.capacity, // 桶的容量
-		r.options.leakyBucketOptions.leakRate, // 漏水速率, 单位是每秒漏多少个请求
-		r.currentTime.Unix(),                  // 单位秒
-	}
-	res, err := EvalSha(r.ctx, r.client, r.getScriptSha(), []string{r.redisKey}, options...)
-
-	// 脚本缓存丢失时执行一次使用脚本重查
-	if err != nil && err.Error() == NoScriptMsg {
-		res, err = Eval(r.ctx, r.client, r.getScript(), []string{r.redisKey}, options...)
+// tokenBucketParams 计算令牌桶脚本参数: 令牌的产生间隔(ms)、重置桶内令牌的时间间隔(ms)、初始令牌数
+//
+// 产生间隔与重置间隔均保留小数, 脚本按小数累计不足一个令牌的时间, 长期速率与配置一致
+func tokenBucketParams(opt tokenBucketOptions) (intervalPerPermit, resetBucketInterval float64, initTokens int64) {
+	// 最大令牌数   -- 对应限流大小
+	bucketMaxTokens := opt.maxTokens
+	if opt.permitInterval > 0 {
+		// 按速率设置时, 重置间隔为填满整桶的时间
+		intervalPerPermit = opt.permitInterval
+		resetBucketInterval = intervalPerPermit * float64(bucketMaxTokens)
+	} else {
+		// 限流时间间隔 -- 对应时间窗口
+		resetBucketInterval = float64(opt.timeInterval)
+		// 令牌的产生间隔 = 限流时间 / 最大令牌数
+		intervalPerPermit = resetBucketInterval / float64(bucketMaxTokens)
 	}
-
-	if err != nil {
-		return 0, err
+	// 初始令牌数
+	initTokens = opt.initTokens
+	// 用 最大的突发流量的持续时间 计算的结果更加合理,并不是每次初始化都要将桶装满
+	if initTokens > bucketMaxTokens {
+		initTokens = bucketMaxTokens
 	}
 
-	return cast.ToInt64(res), nil
+	return intervalPerPermit, resetBucketInterval, initTokens
 }
 
-// getScript 获取限流器执行脚本
-func (r *RateLimiter) getScript() (script string) {
-	return getLuaScript(r.limiterType, compressFlag)
-}
-
-// getScriptSha 获取限流器执行脚本Sha值
-func (r *RateLimiter) getScriptSha() (sha1 string) {
-	switch r.limiterType {
-	case FixedWindowType:
-		sha1 = ScriptShas.FixedWindow
-	case SlideWindowType:
-		sha1 = ScriptShas.SlideWindow
-	case TokenBucketType:
-		sha1 = ScriptShas.TokenBucket
-	case LeakyBucketType:
-		sha1 = ScriptShas.LeakyBucket
-	}
-
-	if sha1 == "" {
-		if ok := ScriptFlush(r.ctx, r.client); ok {
-			loadRedisScript(r.client)
-		}
+// doLeakyBucketLimiter 执行漏桶限流
+func (r *RateLimiter) doLeakyBucketLimiter(ctx context.Context, call limiterCall, n int64, reserve bool) (Decision, error) {
+	options := []interface{}{
+		call.options.leakyBucketOptions.capacity, // 桶的容量
+		call.options.leakyBucketOptions.leakRate, // 漏水速率, 单位是每秒漏多少个请求, 可为小数
+		call.now.UnixMilli(),                     // 单位毫秒
+		n,                                        // 本次消耗的许可数
+		cast.ToInt(reserve),                      // 是否为预约模式
+		call.options.leakyBucketOptions.expiration, // Key 过期时间, 单位毫秒
+	}
+	res, err := r.client.evalScript(ctx, "LeakyBucketScript", []string{call.key}, options...)
+	if err != nil {
+		return Decision{}, err
 	}
 
-	return sha1
+	return parseDecision(res, call.now)
 }
 
-// genLimiterKey 生成存储Key
-func (r *RateLimiter) genLimiterKey() string {
+// genLimiterKey 按指定时间生成存储Key, 格式为不含后缀的存储Key加窗口/分片后缀
+func (r *RateLimiter) genLimiterKey(baseKey string, opts Options, now time.Time) string {
 	var (
 		suffix     string
 		limitCount int64
@@ -400,34 +651,32 @@ func (r *RateLimiter) genLimiterKey() string {
 	// 获取对应限流器类型的 LimitCount
 	switch r.limiterType {
 	case FixedWindowType: // 以时间戳作为后缀
-		limitCount = r.options.fixedWindowOptions.limitCount
+		limitCount = opts.fixedWindowOptions.limitCount
 		// 固定窗口类型需要添加时间戳后缀
-		suffix = cast.ToString(math.Floor(float64(r.currentTime.Unix()) / float64(r.options.fixedWindowOptions.unitTime)))
+		suffix = cast.ToString(math.Floor(float64(now.UnixMilli()) / float64(opts.fixedWindowOptions.unitTime)))
 	case SlideWindowType: // 固定KEY，无后缀
-		limitCount = r.options.slideWindowOptions.limitCount
+		limitCount = opts.slideWindowOptions.limitCount
 	case TokenBucketType: // 固定KEY，无后缀
-		limitCount = r.options.tokenBucketOptions.maxTokens
+		limitCount = opts.tokenBucketOptions.maxTokens
 	case LeakyBucketType: // 固定KEY，无后缀
-		limitCount = r.options.leakyBucketOptions.leakRate
+		limitCount = cast.ToInt64(math.Ceil(opts.leakyBucketOptions.leakRate))
+	case CalendarQuotaType: // 以周期开始时间(ms)作为后缀, Key 随周期滚动
+		limitCount = opts.calendarQuotaOptions.limitCount
+		suffix = cast.ToString(opts.calendarQuotaOptions.window(now).start.UnixMilli())
 	}
 
 	// 处理大容量限流的情况，防止热Key
 	mod := 0
 	if limitCount > MaxBucketCapacity {
 		tmp := math.Ceil(float64(limitCount) / float64(MaxBucketCapacity))
-		mod = time.Now().Nanosecond() % cast.ToInt(tmp)
+		mod = now.Nanosecond() % cast.ToInt(tmp)
 	}
 
 	if len(suffix) == 0 {
 		suffix = cast.ToString(mod)
 	} else {
-		suffix += "::" + cast.ToString(mod)
-	}
-
-	ret := RedisKeyPrefix + "::" + string(r.limiterType) + "::" + r.product
-	if len(suffix) > 0 {
-		ret += "::" + suffix
+		suffix += keySeparator + cast.ToString(mod)
 	}
 
-	return ret
+	return baseKey + keySeparator + suffix
 }
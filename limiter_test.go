@@ -7,13 +7,17 @@ package ratelimiter
 import (
 	"context"
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
 )
 
 var (
@@ -163,6 +167,1621 @@ func TestLimiter_WithRedisKey(t *testing.T) {
 	t.Logf("custom key[%v] ret2[%v] err2[%v]", key2, ret2, err2)
 }
 
+// go test . -v -run=TestLimiter_Decision
+func TestLimiter_Decision(t *testing.T) {
+	tests := []struct {
+		name        string
+		limiterType LimiterType
+		options     Options
+		limit       int64
+	}{
+		{"固定窗口", FixedWindowType, NewFixedWindowOption(3, 10), 3},
+		{"令牌桶", TokenBucketType, NewTokenBucketOption(3, 10, 3), 3},
+		{"漏桶", LeakyBucketType, NewLeakyBucketOption(3, 1), 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			product := fmt.Sprintf("test_decision_%d", time.Now().UnixNano())
+			obj := NewRateLimiter(product, tt.limiterType, tt.options)
+
+			var last Decision
+			for i := int64(0); i < tt.limit+2; i++ {
+				res, err := obj.Do()
+				if err != nil {
+					t.Fatalf("Do fail, err[%v]", err)
+				}
+				t.Logf("index[%v] decision[%+v]", i, res)
+
+				if res.Limit != tt.limit {
+					t.Errorf("limit %d, want %d", res.Limit, tt.limit)
+				}
+				if res.Allowed && res.Remaining >= tt.limit {
+					t.Errorf("remaining %d should be less than limit %d", res.Remaining, tt.limit)
+				}
+				last = res
+			}
+
+			if last.Allowed {
+				t.Fatalf("request over limit should be rejected")
+			}
+			if last.Remaining != 0 || last.RetryAfter <= 0 {
+				t.Errorf("rejected decision should carry retry hint, got %+v", last)
+			}
+			if !last.ResetAt.After(time.Now()) {
+				t.Errorf("reset time %v should be in the future", last.ResetAt)
+			}
+		})
+	}
+}
+
+// go test . -v -run=TestLimiter_AllowN
+func TestLimiter_AllowN(t *testing.T) {
+	tests := []struct {
+		name        string
+		limiterType LimiterType
+		options     Options
+	}{
+		{"固定窗口", FixedWindowType, NewFixedWindowOption(5, 10)},
+		{"滑动窗口", SlideWindowType, NewSlideWindowOption(5, 10)},
+		{"令牌桶", TokenBucketType, NewTokenBucketOption(5, 10, 5)},
+		{"漏桶", LeakyBucketType, NewLeakyBucketOption(5, 1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			product := fmt.Sprintf("test_allown_%d", time.Now().UnixNano())
+			obj := NewRateLimiter(product, tt.limiterType, tt.options)
+			ctx := context.TODO()
+
+			res, err := obj.AllowN(ctx, 3)
+			assert.NoError(t, err)
+			assert.True(t, res.Allowed)
+			assert.Equal(t, int64(2), res.Remaining)
+
+			// 许可不足时整体拒绝, 不会部分消耗
+			res, err = obj.AllowN(ctx, 3)
+			assert.NoError(t, err)
+			assert.False(t, res.Allowed)
+			assert.Equal(t, int64(2), res.Remaining)
+			assert.Greater(t, res.RetryAfter, time.Duration(0))
+
+			res, err = obj.AllowN(ctx, 2)
+			assert.NoError(t, err)
+			assert.True(t, res.Allowed)
+			assert.Equal(t, int64(0), res.Remaining)
+
+			// 超过限流大小的请求永远无法满足
+			res, err = obj.AllowN(ctx, 6)
+			assert.NoError(t, err)
+			assert.False(t, res.Allowed)
+			assert.Less(t, res.RetryAfter, time.Duration(0))
+
+			_, err = obj.AllowN(ctx, 0)
+			assert.Error(t, err)
+		})
+	}
+}
+
+// go test . -v -run=TestLimiter_Wait
+func TestLimiter_Wait(t *testing.T) {
+	product := fmt.Sprintf("test_wait_%d", time.Now().UnixNano())
+	// 每 500ms 生成一个令牌
+	obj := NewRateLimiter(product, TokenBucketType, NewTokenBucketOption(2, 1, 1))
+
+	start := time.Now()
+	assert.NoError(t, obj.Wait(context.TODO()))
+	assert.NoError(t, obj.Wait(context.TODO()))
+	elapsed := time.Since(start)
+	t.Logf("wait elapsed[%v]", elapsed)
+	assert.GreaterOrEqual(t, elapsed, 400*time.Millisecond)
+
+	// 截止时间内无法获取许可时提前返回
+	ctx, cancel := context.WithTimeout(context.TODO(), 100*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	assert.Error(t, obj.Wait(ctx))
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+
+	// 超过令牌桶上限的请求直接返回错误
+	assert.Error(t, obj.WaitN(context.TODO(), 3))
+}
+
+// go test . -v -run=TestLimiter_Reserve
+func TestLimiter_Reserve(t *testing.T) {
+	ctx := context.TODO()
+
+	t.Run("令牌桶", func(t *testing.T) {
+		product := fmt.Sprintf("test_reserve_%d", time.Now().UnixNano())
+		// 每 500ms 生成一个令牌, 初始无令牌
+		obj := NewRateLimiter(product, TokenBucketType, NewTokenBucketOption(2, 1, 0))
+
+		r1, err := obj.Reserve(ctx)
+		assert.NoError(t, err)
+		assert.True(t, r1.OK())
+		assert.InDelta(t, 500*time.Millisecond, r1.Delay(), float64(50*time.Millisecond))
+
+		r2, err := obj.Reserve(ctx)
+		assert.NoError(t, err)
+		assert.True(t, r2.OK())
+		assert.InDelta(t, time.Second, r2.Delay(), float64(50*time.Millisecond))
+
+		// 透支超过重置间隔时预约失败
+		r3, err := obj.Reserve(ctx)
+		assert.NoError(t, err)
+		assert.False(t, r3.OK())
+
+		// 取消预约后归还令牌, 可以重新预约
+		assert.NoError(t, r2.Cancel(ctx))
+		r4, err := obj.Reserve(ctx)
+		assert.NoError(t, err)
+		assert.True(t, r4.OK())
+	})
+
+	t.Run("漏桶", func(t *testing.T) {
+		product := fmt.Sprintf("test_reserve_%d", time.Now().UnixNano())
+		obj := NewRateLimiter(product, LeakyBucketType, NewLeakyBucketOption(2, 1))
+
+		for i := 0; i < 2; i++ {
+			r, err := obj.Reserve(ctx)
+			assert.NoError(t, err)
+			assert.True(t, r.OK())
+			assert.Equal(t, time.Duration(0), r.Delay())
+		}
+
+		// 桶满后排队等待漏出
+		r1, err := obj.Reserve(ctx)
+		assert.NoError(t, err)
+		assert.True(t, r1.OK())
+		assert.Greater(t, r1.Delay(), time.Duration(0))
+
+		// 普通请求不能插队
+		res, err := obj.Do()
+		assert.NoError(t, err)
+		assert.False(t, res.Allowed)
+
+		assert.NoError(t, r1.Cancel(ctx))
+		r2, err := obj.ReserveN(ctx, 2)
+		assert.NoError(t, err)
+		assert.True(t, r2.OK())
+	})
+
+	t.Run("未扣减 Redis 的预约不归还", func(t *testing.T) {
+		rdb := redis.NewClient(&redis.Options{
+			Addr:        "127.0.0.1:1",
+			MaxRetries:  -1,
+			DialTimeout: 100 * time.Millisecond,
+		})
+		defer rdb.Close()
+		now := time.Now()
+		cli := New(rdb, WithClock(func() time.Time { return now }))
+		defer cli.Close()
+
+		obj := cli.NewRateLimiter("test", TokenBucketType, NewTokenBucketOption(2, 1, 0)).WithFailurePolicy(FailOpen)
+		r, err := obj.Reserve(ctx)
+		assert.NoError(t, err)
+		assert.True(t, r.OK())
+		assert.Empty(t, r.Decision().Key)
+
+		// 可执行时间之前取消, FailOpen 放行的预约不访问 Redis
+		now = now.Add(-time.Second)
+		assert.NoError(t, r.Cancel(ctx))
+	})
+
+	t.Run("不支持的类型", func(t *testing.T) {
+		obj := NewRateLimiter("test", FixedWindowType, NewFixedWindowOption(2, 1))
+		_, err := obj.Reserve(ctx)
+		assert.ErrorIs(t, err, ErrUnsupported)
+	})
+}
+
+// go test . -v -run=TestLimiter_Validate
+func TestLimiter_Validate(t *testing.T) {
+	tests := []struct {
+		name           string
+		limiterType    LimiterType
+		options        Options
+		wantErr        error
+		wantExpiration int64
+	}{
+		{"固定窗口-正常", FixedWindowType, NewFixedWindowOption(10, 1), nil, 300000},
+		{"固定窗口-长窗口", FixedWindowType, NewFixedWindowOption(10, 3600), nil, 3600000},
+		{"固定窗口-限流大小为0", FixedWindowType, NewFixedWindowOption(0, 1), ErrInvalidOptions, 0},
+		{"固定窗口-窗口为0", FixedWindowType, NewFixedWindowOption(10, 0), ErrInvalidOptions, 0},
+		{"固定窗口-窗口为负", FixedWindowType, NewFixedWindowOption(10, -1), ErrInvalidOptions, 0},
+		{"滑动窗口-正常", SlideWindowType, NewSlideWindowOption(10, 60), nil, 120000},
+		{"滑动窗口-限流大小为0", SlideWindowType, NewSlideWindowOption(0, 60), ErrInvalidOptions, 0},
+		{"滑动窗口-窗口为0", SlideWindowType, NewSlideWindowOption(10, 0), ErrInvalidOptions, 0},
+		{"滑动窗口-误用固定窗口参数", SlideWindowType, NewFixedWindowOption(10, 60), ErrInvalidOptions, 0},
+		{"令牌桶-正常", TokenBucketType, NewTokenBucketOption(10, 1, 5), nil, 0},
+		{"令牌桶-上限为0", TokenBucketType, NewTokenBucketOption(0, 1, 0), ErrInvalidOptions, 0},
+		{"令牌桶-间隔为0", TokenBucketType, NewTokenBucketOption(10, 0, 5), ErrInvalidOptions, 0},
+		{"令牌桶-初始令牌为负", TokenBucketType, NewTokenBucketOption(10, 1, -1), ErrInvalidOptions, 0},
+		{"漏桶-正常", LeakyBucketType, NewLeakyBucketOption(10, 3), nil, 6668},
+		{"漏桶-容量为0", LeakyBucketType, NewLeakyBucketOption(0, 1), ErrInvalidOptions, 0},
+		{"漏桶-速率为0", LeakyBucketType, NewLeakyBucketOption(10, 0), ErrInvalidOptions, 0},
+		{"未知类型", LimiterType("Unknown"), NewFixedWindowOption(10, 1), ErrUnknownLimiterType, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			product := fmt.Sprintf("test_validate_%d", time.Now().UnixNano())
+			obj := NewRateLimiter(product, tt.limiterType, tt.options)
+			assert.ErrorIs(t, obj.Validate(), tt.wantErr)
+
+			res, err := obj.Do()
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.True(t, res.Allowed)
+
+			// 过期时间由窗口大小或漏空时间推导
+			if tt.wantExpiration > 0 {
+				ttl, err := client.PTTL(context.TODO(), obj.GetRedisKey()).Result()
+				assert.NoError(t, err)
+				assert.InDelta(t, tt.wantExpiration, ttl.Milliseconds(), 50)
+			}
+		})
+	}
+}
+
+// go test . -v -run=TestNewOptions
+func TestNewOptions(t *testing.T) {
+	tests := []struct {
+		name        string
+		limiterType LimiterType
+		opts        []Option
+		want        Options
+		wantErr     error
+	}{
+		{
+			name:        "固定窗口-限流大小与窗口",
+			limiterType: FixedWindowType,
+			opts:        []Option{WithLimit(100), WithWindow(time.Minute)},
+			want:        Options{fixedWindowOptions: fixedWindowOptions{limitCount: 100, unitTime: 60000}},
+		},
+		{
+			name:        "固定窗口-速率",
+			limiterType: FixedWindowType,
+			opts:        []Option{WithRate(100, time.Minute), WithTTL(90 * time.Second)},
+			want:        Options{fixedWindowOptions: fixedWindowOptions{limitCount: 100, unitTime: 60000, expiration: 90000}},
+		},
+		{
+			name:        "滑动窗口-速率",
+			limiterType: SlideWindowType,
+			opts:        []Option{WithRate(10, 10*time.Second)},
+			want:        Options{slideWindowOptions: slideWindowOptions{limitCount: 10, unitTime: 10000}},
+		},
+		{
+			name:        "令牌桶-窗口",
+			limiterType: TokenBucketType,
+			opts:        []Option{WithLimit(10), WithWindow(time.Second)},
+			want:        Options{tokenBucketOptions: tokenBucketOptions{maxTokens: 10, initTokens: 10, timeInterval: 1000}},
+		},
+		{
+			name:        "令牌桶-速率与突发",
+			limiterType: TokenBucketType,
+			opts:        []Option{WithRate(100, time.Minute), WithBurst(20), WithTTL(time.Hour)},
+			want:        Options{tokenBucketOptions: tokenBucketOptions{maxTokens: 20, initTokens: 20, permitInterval: 600, expiration: 3600000}},
+		},
+		{
+			name:        "漏桶-速率与容量",
+			limiterType: LeakyBucketType,
+			opts:        []Option{WithBurst(50), WithRate(10, time.Second)},
+			want:        Options{leakyBucketOptions: leakyBucketOptions{capacity: 50, leakRate: 10}},
+		},
+		{"固定窗口-缺少窗口", FixedWindowType, []Option{WithLimit(10)}, Options{}, ErrInvalidOptions},
+		{"固定窗口-不支持突发", FixedWindowType, []Option{WithLimit(10), WithWindow(time.Second), WithBurst(5)}, Options{}, ErrInvalidOptions},
+		{"固定窗口-速率冲突", FixedWindowType, []Option{WithLimit(10), WithRate(10, time.Second)}, Options{}, ErrInvalidOptions},
+		{"固定窗口-亚秒窗口", FixedWindowType, []Option{WithLimit(10), WithWindow(100 * time.Millisecond)}, Options{fixedWindowOptions: fixedWindowOptions{limitCount: 10, unitTime: 100}}, nil},
+		{"固定窗口-非整数毫秒", FixedWindowType, []Option{WithLimit(10), WithWindow(1500 * time.Microsecond)}, Options{}, ErrInvalidOptions},
+		{"滑动窗口-过期时间短于窗口", SlideWindowType, []Option{WithRate(10, time.Minute), WithTTL(time.Second)}, Options{}, ErrInvalidOptions},
+		{"令牌桶-过期时间短于填满时间", TokenBucketType, []Option{WithRate(10, time.Second), WithBurst(100), WithTTL(5 * time.Second)}, Options{}, ErrInvalidOptions},
+		{"令牌桶-缺少上限", TokenBucketType, []Option{WithWindow(time.Second)}, Options{}, ErrInvalidOptions},
+		{"令牌桶-上限冲突", TokenBucketType, []Option{WithLimit(10), WithBurst(20), WithWindow(time.Second)}, Options{}, ErrInvalidOptions},
+		{"令牌桶-速率与窗口冲突", TokenBucketType, []Option{WithRate(10, time.Second), WithWindow(time.Second)}, Options{}, ErrInvalidOptions},
+		{"漏桶-不支持窗口", LeakyBucketType, []Option{WithLimit(10), WithWindow(time.Second)}, Options{}, ErrInvalidOptions},
+		{"漏桶-缺少速率", LeakyBucketType, []Option{WithLimit(10)}, Options{}, ErrInvalidOptions},
+		{"漏桶-小数速率", LeakyBucketType, []Option{WithLimit(10), WithRate(1, 3*time.Second)}, Options{leakyBucketOptions: leakyBucketOptions{capacity: 10, leakRate: 1.0 / 3}}, nil},
+		{"令牌桶-小数间隔", TokenBucketType, []Option{WithBurst(5), WithRate(3, time.Second)}, Options{tokenBucketOptions: tokenBucketOptions{maxTokens: 5, initTokens: 5, permitInterval: 1000.0 / 3}}, nil},
+		{"未知类型", LimiterType("Unknown"), []Option{WithLimit(10)}, Options{}, ErrUnknownLimiterType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewOptions(tt.limiterType, tt.opts...)
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	// 旧的整数构造函数与函数式参数等价
+	got, err := NewOptions(TokenBucketType, WithLimit(10), WithWindow(20*time.Second))
+	assert.NoError(t, err)
+	want := NewTokenBucketOption(10, 20, 10)
+	assert.Equal(t, want, got)
+
+	// 令牌桶按速率生成令牌, 突发容量用尽后需等待一个令牌间隔
+	product := fmt.Sprintf("test_options_%d", time.Now().UnixNano())
+	obj, err := NewLimiter(product, TokenBucketType, WithRate(10, time.Second), WithBurst(2))
+	assert.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		res, err := obj.Do()
+		assert.NoError(t, err)
+		assert.True(t, res.Allowed)
+	}
+	res, err := obj.Do()
+	assert.NoError(t, err)
+	assert.False(t, res.Allowed)
+	assert.LessOrEqual(t, res.RetryAfter, 100*time.Millisecond)
+}
+
+// go test . -v -run=TestLimiter_Millisecond
+func TestLimiter_Millisecond(t *testing.T) {
+	tests := []struct {
+		name        string
+		limiterType LimiterType
+		opts        []Option
+	}{
+		{"固定窗口", FixedWindowType, []Option{WithLimit(2), WithWindow(100 * time.Millisecond)}},
+		{"滑动窗口", SlideWindowType, []Option{WithLimit(2), WithWindow(100 * time.Millisecond)}},
+		{"令牌桶", TokenBucketType, []Option{WithBurst(2), WithRate(1, 50*time.Millisecond)}},
+		{"漏桶", LeakyBucketType, []Option{WithBurst(2), WithRate(20, time.Second)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			product := fmt.Sprintf("test_ms_%d", time.Now().UnixNano())
+			obj, err := NewLimiter(product, tt.limiterType, tt.opts...)
+			assert.NoError(t, err)
+
+			for i := 0; i < 2; i++ {
+				res, err := obj.Do()
+				assert.NoError(t, err)
+				assert.True(t, res.Allowed)
+			}
+
+			// 亚秒级窗口/速率下重试间隔不超过 100ms, 等待后即可放行
+			res, err := obj.Do()
+			assert.NoError(t, err)
+			assert.False(t, res.Allowed)
+			assert.Greater(t, res.RetryAfter, time.Duration(0))
+			assert.LessOrEqual(t, res.RetryAfter, 100*time.Millisecond)
+
+			time.Sleep(res.RetryAfter + 5*time.Millisecond)
+			res, err = obj.Do()
+			assert.NoError(t, err)
+			assert.True(t, res.Allowed)
+		})
+	}
+}
+
+// 长期吞吐量与配置的小数速率一致, 通过模拟时钟在数秒内执行10分钟的请求
+// go test . -v -run=TestLimiter_FloatRate
+func TestLimiter_FloatRate(t *testing.T) {
+	const (
+		duration = 10 * time.Minute
+		step     = 50 * time.Millisecond
+		burst    = 2
+	)
+
+	tests := []struct {
+		name        string
+		limiterType LimiterType
+		rate        float64 // 每秒请求数
+		opts        []Option
+	}{
+		{"令牌桶-每秒3个", TokenBucketType, 3, []Option{WithBurst(burst), WithRate(3, time.Second)}},
+		{"令牌桶-每3秒1个", TokenBucketType, 1.0 / 3, []Option{WithBurst(burst), WithRate(1, 3*time.Second)}},
+		{"令牌桶-每秒2.5个", TokenBucketType, 2.5, []Option{WithBurst(burst), WithRate(5, 2*time.Second)}},
+		{"漏桶-每秒3个", LeakyBucketType, 3, []Option{WithBurst(burst), WithRate(3, time.Second)}},
+		{"漏桶-每3秒1个", LeakyBucketType, 1.0 / 3, []Option{WithBurst(burst), WithRate(1, 3*time.Second)}},
+		{"漏桶-每秒2.5个", LeakyBucketType, 2.5, []Option{WithBurst(burst), WithRate(5, 2*time.Second)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now()
+			c := New(client, WithClock(func() time.Time { return now }))
+			defer c.Close()
+
+			product := fmt.Sprintf("test_float_rate_%d", time.Now().UnixNano())
+			obj, err := c.NewLimiter(product, tt.limiterType, tt.opts...)
+			assert.NoError(t, err)
+
+			allowed := 0
+			for elapsed := time.Duration(0); elapsed < duration; elapsed += step {
+				res, err := obj.Do()
+				assert.NoError(t, err)
+				if res.Allowed {
+					allowed++
+				}
+				now = now.Add(step)
+			}
+
+			// 突发容量 + 速率 * 时长, 误差不超过1个请求
+			want := float64(burst) + tt.rate*duration.Seconds()
+			assert.InDelta(t, want, allowed, 1)
+		})
+	}
+}
+
+// go test . -v -run=TestLimiter_Errors
+func TestLimiter_Errors(t *testing.T) {
+	ctx := context.TODO()
+	product := fmt.Sprintf("test_errors_%d", time.Now().UnixNano())
+
+	t.Run("请求被限流", func(t *testing.T) {
+		obj := NewRateLimiter(product, FixedWindowType, NewFixedWindowOption(1, 3600))
+		res, err := obj.Do()
+		assert.NoError(t, err)
+		assert.NoError(t, res.Err())
+
+		res, err = obj.Do()
+		assert.NoError(t, err)
+		assert.ErrorIs(t, res.Err(), ErrLimited)
+
+		waitCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, obj.Wait(waitCtx), ErrLimited)
+	})
+
+	t.Run("未知限流器类型", func(t *testing.T) {
+		obj := NewRateLimiter(product, LimiterType("Unknown"), NewFixedWindowOption(1, 1))
+		_, err := obj.Do()
+		assert.ErrorIs(t, err, ErrUnknownLimiterType)
+		_, err = obj.Inspect(ctx)
+		assert.ErrorIs(t, err, ErrUnknownLimiterType)
+		assert.ErrorIs(t, obj.Refund(ctx, &Decision{Allowed: true, Key: product, cost: 1}, 1), ErrUnknownLimiterType)
+		assert.ErrorIs(t, ResetProduct(ctx, product, LimiterType("Unknown")), ErrUnknownLimiterType)
+	})
+
+	t.Run("非法许可数", func(t *testing.T) {
+		obj := NewRateLimiter(product, FixedWindowType, NewFixedWindowOption(1, 1))
+		_, err := obj.AllowN(ctx, 0)
+		assert.ErrorIs(t, err, ErrInvalidOptions)
+		assert.ErrorIs(t, obj.Refund(ctx, &Decision{}, -1), ErrInvalidOptions)
+	})
+
+	t.Run("Redis 不可用", func(t *testing.T) {
+		rdb := redis.NewClient(&redis.Options{
+			Addr:        "127.0.0.1:1",
+			MaxRetries:  -1,
+			DialTimeout: 100 * time.Millisecond,
+		})
+		defer rdb.Close()
+		cli := New(rdb)
+		defer cli.Close()
+
+		obj := cli.NewRateLimiter(product, FixedWindowType, NewFixedWindowOption(1, 1))
+
+		_, err := obj.Do()
+		assert.ErrorIs(t, err, ErrBackendUnavailable)
+		_, err = obj.Inspect(ctx)
+		assert.ErrorIs(t, err, ErrBackendUnavailable)
+		assert.ErrorIs(t, obj.Reset(ctx), ErrBackendUnavailable)
+
+		composite, err := NewComposite(Rule{Name: "a", Limiter: obj})
+		assert.NoError(t, err)
+		_, err = composite.Allow(ctx)
+		assert.ErrorIs(t, err, ErrBackendUnavailable)
+	})
+}
+
+// go test . -v -run=TestFailurePolicy
+func TestFailurePolicy(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{
+		Addr:        "127.0.0.1:1",
+		MaxRetries:  -1,
+		DialTimeout: 100 * time.Millisecond,
+	})
+	defer rdb.Close()
+	cli := New(rdb)
+	defer cli.Close()
+
+	handler := NewLogHandler()
+	cli.RegisterHandler("failure", handler)
+
+	tests := []struct {
+		policy  FailurePolicy
+		allowed bool
+	}{
+		{FailOpen, true},
+		{FailClosed, false},
+		{FallbackLocal, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy.String(), func(t *testing.T) {
+			obj := cli.NewRateLimiter("test_failure", TokenBucketType, NewTokenBucketOption(10, 1, 10)).WithFailurePolicy(tt.policy)
+			res, err := obj.Do()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.allowed, res.Allowed)
+			assert.Equal(t, tt.policy, res.Fallback)
+			assert.Equal(t, int64(10), res.Limit)
+			if !tt.allowed {
+				assert.ErrorIs(t, res.Err(), ErrLimited)
+				assert.Greater(t, res.RetryAfter, time.Duration(0))
+			}
+		})
+	}
+
+	// 默认策略仍返回错误, 参数错误不受故障处理策略影响
+	_, err := cli.NewRateLimiter("test_failure", TokenBucketType, NewTokenBucketOption(10, 1, 10)).Do()
+	assert.ErrorIs(t, err, ErrBackendUnavailable)
+	_, err = cli.NewRateLimiter("test_failure", TokenBucketType, NewTokenBucketOption(0, 1, 10)).WithFailurePolicy(FailOpen).Do()
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+
+	// 限流记录标记故障处理策略并保留原始错误, FallbackLocal 额外上报一条本地限流启用记录
+	time.Sleep(100 * time.Millisecond)
+	var records, events []LimiterRecord
+	for _, record := range handler.GetRecords() {
+		if record.Event == EventDecision {
+			records = append(records, record)
+		} else {
+			events = append(events, record)
+		}
+	}
+	assert.Len(t, records, 5)
+	for i, tt := range tests {
+		assert.Equal(t, tt.policy, records[i].Fallback)
+		assert.Equal(t, tt.policy, records[i].Result.Fallback)
+		assert.ErrorIs(t, records[i].Error, ErrBackendUnavailable)
+	}
+	assert.Equal(t, FailError, records[3].Fallback)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, EventFallbackActivated, events[0].Event)
+		assert.ErrorIs(t, events[0].Error, ErrBackendUnavailable)
+	}
+}
+
+// go test . -v -run=TestLocalFallback
+func TestLocalFallback(t *testing.T) {
+	// 通过开关模拟 Redis 宕机及恢复
+	var down int32 = 1
+	rdb := redis.NewClient(&redis.Options{
+		Addr:       client.Options().Addr,
+		MaxRetries: -1,
+		Dialer: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if atomic.LoadInt32(&down) == 1 {
+				return nil, fmt.Errorf("redis is down")
+			}
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	})
+	defer rdb.Close()
+	cli := New(rdb, WithInstanceCount(2), WithHealthCheckInterval(50*time.Millisecond))
+	defer cli.Close()
+
+	handler := NewLogHandler()
+	cli.RegisterHandler("local", handler)
+
+	tests := []struct {
+		name        string
+		limiterType LimiterType
+		options     Options
+		wantLimit   int64
+	}{
+		{"固定窗口", FixedWindowType, NewFixedWindowOption(10, 60), 5},
+		{"滑动窗口", SlideWindowType, NewSlideWindowOption(10, 60), 5},
+		{"令牌桶", TokenBucketType, NewTokenBucketOption(10, 60, 10), 5},
+		{"漏桶", LeakyBucketType, NewLeakyBucketOption(10, 1), 5},
+		{"日历配额", CalendarQuotaType, NewCalendarQuotaOption(10, PeriodDay, nil), 5},
+	}
+
+	product := fmt.Sprintf("test_local_%d", time.Now().UnixNano())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := cli.NewRateLimiter(product, tt.limiterType, tt.options).WithFailurePolicy(FallbackLocal)
+
+			// 本地限流大小按实例数均分
+			res, err := obj.AllowN(context.TODO(), tt.wantLimit)
+			assert.NoError(t, err)
+			assert.True(t, res.Allowed)
+			assert.Equal(t, FallbackLocal, res.Fallback)
+			assert.Equal(t, tt.wantLimit, res.Limit)
+			assert.Equal(t, int64(0), res.Remaining)
+
+			res, err = obj.Do()
+			assert.NoError(t, err)
+			assert.False(t, res.Allowed)
+			assert.Equal(t, FallbackLocal, res.Fallback)
+			assert.Greater(t, res.RetryAfter, time.Duration(0))
+
+			res, err = obj.AllowN(context.TODO(), tt.wantLimit+1)
+			assert.NoError(t, err)
+			assert.False(t, res.Allowed)
+			assert.Equal(t, time.Duration(-1), res.RetryAfter)
+		})
+	}
+
+	// Redis 恢复后健康检查切回 Redis 限流
+	atomic.StoreInt32(&down, 0)
+	assert.Eventually(t, func() bool { return !cli.local.isActive() }, 2*time.Second, 10*time.Millisecond)
+
+	obj := cli.NewRateLimiter(product, FixedWindowType, NewFixedWindowOption(10, 60)).WithFailurePolicy(FallbackLocal)
+	res, err := obj.Do()
+	assert.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, FailError, res.Fallback)
+	assert.Equal(t, int64(10), res.Limit)
+
+	// 启用与恢复各上报一次
+	time.Sleep(100 * time.Millisecond)
+	var events []RecordEvent
+	for _, record := range handler.GetRecords() {
+		if record.Event != EventDecision {
+			events = append(events, record.Event)
+		}
+	}
+	assert.Equal(t, []RecordEvent{EventFallbackActivated, EventFallbackRecovered}, events)
+}
+
+// go test . -v -run=TestLimiter_DryRun
+func TestLimiter_DryRun(t *testing.T) {
+	cli := New(client)
+	defer cli.Close()
+
+	handler := NewLogHandler()
+	cli.RegisterHandler("dryrun", handler)
+
+	product := fmt.Sprintf("test_dryrun_%d", time.Now().UnixNano())
+	obj := cli.NewRateLimiter(product, FixedWindowType, NewFixedWindowOption(2, 60)).WithDryRun(true)
+
+	// 调用方始终放行
+	for i := 0; i < 4; i++ {
+		res, err := obj.Do()
+		assert.NoError(t, err)
+		assert.True(t, res.Allowed)
+		assert.Equal(t, time.Duration(0), res.RetryAfter)
+		assert.NoError(t, res.Err())
+	}
+
+	// 脚本照常执行, 关闭试运行后按实际状态拒绝
+	res, err := obj.WithDryRun(false).Do()
+	assert.NoError(t, err)
+	assert.False(t, res.Allowed)
+
+	// 限流记录保留实际决策
+	time.Sleep(100 * time.Millisecond)
+	records := handler.GetRecords()
+	if assert.Len(t, records, 5) {
+		for i, want := range []bool{true, true, false, false} {
+			assert.True(t, records[i].DryRun)
+			assert.Equal(t, want, records[i].Result.Allowed)
+		}
+		assert.Greater(t, records[2].Result.RetryAfter, time.Duration(0))
+		assert.False(t, records[4].DryRun)
+	}
+}
+
+// go test . -v -run=TestAccessList
+func TestAccessList(t *testing.T) {
+	list, err := NewAccessList("health-checker", "10.0.0.0/8", "2001:db8::/32", "192.168.1.1")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, list.Len())
+	assert.True(t, list.Contains("health-checker"))
+	assert.True(t, list.Contains("10.1.2.3"))
+	assert.True(t, list.Contains("2001:db8::1"))
+	assert.True(t, list.Contains("::ffff:192.168.1.1"))
+	assert.False(t, list.Contains("11.0.0.1"))
+	assert.False(t, list.Contains("user"))
+	assert.False(t, list.Contains(""))
+
+	_, err = NewAccessList("10.0.0.0/33")
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+	assert.ErrorIs(t, list.Reload(context.TODO()), ErrUnsupported)
+
+	// 从 Redis 集合加载, 集合变更后重新加载
+	ctx := context.TODO()
+	cli := New(client)
+	defer cli.Close()
+	key := fmt.Sprintf("test_denylist_%d", time.Now().UnixNano())
+	defer client.Del(ctx, key)
+	assert.NoError(t, client.SAdd(ctx, key, "abuser", "172.16.0.0/12").Err())
+
+	deny, err := cli.LoadAccessList(ctx, key)
+	assert.NoError(t, err)
+	assert.True(t, deny.Contains("abuser"))
+	assert.True(t, deny.Contains("172.16.5.5"))
+
+	// 黑名单直接拒绝, 白名单直接放行, 均不消耗许可
+	handler := NewLogHandler()
+	cli.RegisterHandler("accesslist", handler)
+	product := fmt.Sprintf("test_accesslist_%d", time.Now().UnixNano())
+	policy, err := cli.NewPolicy(product, FixedWindowType, WithLimit(1), WithWindow(time.Minute))
+	assert.NoError(t, err)
+	policy.WithDimension(DimIP).WithAllowList(list).WithDenyList(deny)
+
+	tests := []struct {
+		subject string
+		allowed bool
+		reason  DecisionReason
+	}{
+		{"abuser", false, ReasonDenyList},
+		{"172.16.5.5", false, ReasonDenyList},
+		{"10.1.2.3", true, ReasonAllowList},
+		{"10.1.2.3", true, ReasonAllowList},
+		{"8.8.8.8", true, ReasonLimiter},
+		{"8.8.8.8", false, ReasonLimiter},
+	}
+	for _, tt := range tests {
+		res, err := policy.Allow(ctx, tt.subject)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.allowed, res.Allowed, tt.subject)
+		assert.Equal(t, tt.reason, res.Reason, tt.subject)
+		assert.Equal(t, int64(1), res.Limit)
+		if tt.reason == ReasonDenyList {
+			assert.Equal(t, time.Duration(-1), res.RetryAfter)
+		}
+	}
+	assert.ErrorIs(t, policy.Limiter("abuser").Wait(ctx), ErrLimited)
+
+	// 移出黑名单后按限流算法决策
+	assert.NoError(t, client.SRem(ctx, key, "abuser").Err())
+	assert.NoError(t, deny.Reload(ctx))
+	res, err := policy.Allow(ctx, "abuser")
+	assert.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, ReasonLimiter, res.Reason)
+
+	// 限流记录区分决策依据
+	time.Sleep(100 * time.Millisecond)
+	records := handler.GetRecords()
+	if assert.GreaterOrEqual(t, len(records), len(tests)) {
+		for i, tt := range tests {
+			assert.Equal(t, tt.reason, records[i].Result.Reason)
+		}
+	}
+
+	// 仅匹配主体维度, 其他维度的取值命中名单不影响决策
+	opts, err := NewOptions(FixedWindowType, WithLimit(1), WithWindow(time.Minute))
+	assert.NoError(t, err)
+	obj := cli.NewRateLimiter(product+"_dim", FixedWindowType, opts).
+		WithDimension(DimRoute, "abuser").WithDimension(DimUser, "8.8.4.4").
+		WithSubjectDimension(DimUser).WithAllowList(list).WithDenyList(deny)
+	assert.NoError(t, obj.Validate())
+	res, err = obj.Allow(ctx)
+	assert.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, ReasonLimiter, res.Reason)
+
+	// 设置了名单但存储Key不含主体维度时参数非法
+	obj = cli.NewRateLimiter(product+"_route", FixedWindowType, opts).
+		WithDimension(DimRoute, "health-checker").WithAllowList(list)
+	assert.ErrorIs(t, obj.Validate(), ErrInvalidOptions)
+	_, err = obj.Allow(ctx)
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+	obj.WithSubjectDimension(DimRoute)
+	assert.NoError(t, obj.Validate())
+	res, err = obj.Allow(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, ReasonAllowList, res.Reason)
+}
+
+// go test . -v -run=TestPenalty
+func TestPenalty(t *testing.T) {
+	var mu sync.Mutex
+	now := time.UnixMilli(time.Now().UnixMilli() / 1000 * 1000)
+	advance := func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		now = now.Add(d)
+	}
+	cli := New(client, WithCompress(true), WithClock(func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}))
+	defer cli.Close()
+
+	product := fmt.Sprintf("test_penalty_%d", time.Now().UnixNano())
+	obj := cli.NewRateLimiter(product, FixedWindowType, NewFixedWindowOption(2, 1)).
+		WithDimension(DimUser, "u1").
+		WithPenalty(Penalty{Threshold: 3, Period: 10 * time.Second, Ban: time.Second, MaxBan: 4 * time.Second})
+	assert.NoError(t, obj.Validate())
+
+	// 每个窗口耗尽许可后连续被拒绝3次触发封禁, 封禁时长依次为 1s、2s、4s、4s
+	for _, ban := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		for i := 0; i < 2; i++ {
+			res, err := obj.Do()
+			assert.NoError(t, err)
+			assert.True(t, res.Allowed)
+		}
+		for i := 0; i < 2; i++ {
+			res, err := obj.Do()
+			assert.NoError(t, err)
+			assert.False(t, res.Allowed)
+			assert.Equal(t, ReasonLimiter, res.Reason)
+		}
+
+		res, err := obj.Do()
+		assert.NoError(t, err)
+		assert.False(t, res.Allowed)
+		assert.Equal(t, ReasonPenalty, res.Reason)
+		assert.Equal(t, ban, res.RetryAfter)
+
+		// 封禁期内即使窗口已重置也直接拒绝
+		advance(ban - 200*time.Millisecond)
+		res, err = obj.Do()
+		assert.NoError(t, err)
+		assert.False(t, res.Allowed)
+		assert.Equal(t, ReasonPenalty, res.Reason)
+		assert.Equal(t, 200*time.Millisecond, res.RetryAfter)
+		assert.Equal(t, int64(2), res.Limit)
+
+		advance(time.Second + 200*time.Millisecond)
+	}
+
+	// Reset 同时清除封禁状态
+	_, err := obj.AllowN(context.TODO(), 2)
+	assert.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, _ = obj.Do()
+	}
+	res, err := obj.Do()
+	assert.NoError(t, err)
+	assert.Equal(t, ReasonPenalty, res.Reason)
+	assert.NoError(t, obj.Reset(context.TODO()))
+	res, err = obj.Do()
+	assert.NoError(t, err)
+	assert.True(t, res.Allowed)
+
+	// 其他主体不受影响
+	res, err = obj.WithDimension(DimUser, "u2").Do()
+	assert.NoError(t, err)
+	assert.True(t, res.Allowed)
+
+	invalid := cli.NewRateLimiter(product, FixedWindowType, NewFixedWindowOption(2, 1)).WithPenalty(Penalty{Threshold: 3, Period: time.Second})
+	assert.ErrorIs(t, invalid.Validate(), ErrInvalidOptions)
+	_, err = invalid.Do()
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+}
+
+// go test . -v -run=TestCalendarQuota
+func TestCalendarQuota(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	assert.NoError(t, err)
+
+	// 周期边界按时区日历计算, 夏令时切换当天为 23/25 小时, 回拨时重复的小时为两个周期
+	windows := []struct {
+		name   string
+		period CalendarPeriod
+		now    time.Time
+		start  time.Time
+		length time.Duration
+	}{
+		{"夏令时开始", PeriodDay, time.Date(2024, 3, 10, 12, 0, 0, 0, ny), time.Date(2024, 3, 10, 0, 0, 0, 0, ny), 23 * time.Hour},
+		{"夏令时结束", PeriodDay, time.Date(2024, 11, 3, 12, 0, 0, 0, ny), time.Date(2024, 11, 3, 0, 0, 0, 0, ny), 25 * time.Hour},
+		{"回拨前的小时", PeriodHour, time.Date(2024, 11, 3, 5, 30, 0, 0, time.UTC), time.Date(2024, 11, 3, 5, 0, 0, 0, time.UTC), time.Hour},
+		{"回拨后的小时", PeriodHour, time.Date(2024, 11, 3, 6, 30, 0, 0, time.UTC), time.Date(2024, 11, 3, 6, 0, 0, 0, time.UTC), time.Hour},
+		{"自然周", PeriodWeek, time.Date(2024, 3, 13, 8, 0, 0, 0, ny), time.Date(2024, 3, 11, 0, 0, 0, 0, ny), 7 * 24 * time.Hour},
+		{"跨夏令时的周", PeriodWeek, time.Date(2024, 3, 10, 8, 0, 0, 0, ny), time.Date(2024, 3, 4, 0, 0, 0, 0, ny), 7*24*time.Hour - time.Hour},
+		{"闰年二月", PeriodMonth, time.Date(2024, 2, 29, 23, 0, 0, 0, ny), time.Date(2024, 2, 1, 0, 0, 0, 0, ny), 29 * 24 * time.Hour},
+	}
+	for _, tt := range windows {
+		t.Run(tt.name, func(t *testing.T) {
+			w := calendarQuotaOptions{period: tt.period, location: ny}.window(tt.now)
+			assert.True(t, tt.start.Equal(w.start), "start %v", w.start)
+			assert.Equal(t, tt.length, w.end.Sub(w.start))
+		})
+	}
+
+	_, err = NewOptions(CalendarQuotaType, WithLimit(10), WithPeriod(PeriodMonth), WithLocation(ny))
+	assert.NoError(t, err)
+	for _, opts := range [][]Option{
+		{WithLimit(10)},
+		{WithLimit(0), WithPeriod(PeriodDay)},
+		{WithLimit(10), WithPeriod(PeriodDay), WithWindow(time.Hour)},
+		{WithLimit(10), WithPeriod(PeriodDay), WithTTL(time.Hour)},
+	} {
+		_, err = NewOptions(CalendarQuotaType, opts...)
+		assert.ErrorIs(t, err, ErrInvalidOptions)
+	}
+	_, err = NewOptions(FixedWindowType, WithLimit(10), WithWindow(time.Hour), WithPeriod(PeriodDay))
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+
+	// 上海时间 23:59:59 用完当日配额, 1s 后进入新的一天
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	assert.NoError(t, err)
+	var mu sync.Mutex
+	now := time.Date(2024, 5, 1, 23, 59, 59, 0, shanghai)
+	cli := New(client, WithClock(func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}))
+	defer cli.Close()
+
+	ctx := context.TODO()
+	product := fmt.Sprintf("test_calendar_%d", time.Now().UnixNano())
+	obj := cli.NewRateLimiter(product, CalendarQuotaType, NewCalendarQuotaOption(3, PeriodDay, shanghai))
+
+	res, err := obj.AllowN(ctx, 3)
+	assert.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, int64(0), res.Remaining)
+	assert.True(t, res.ResetAt.Equal(time.Date(2024, 5, 2, 0, 0, 0, 0, shanghai)))
+
+	res, err = obj.Do()
+	assert.NoError(t, err)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, time.Second, res.RetryAfter)
+
+	// Key 以周期开始时间为后缀, 在周期结束时过期
+	call, err := obj.newCall(now)
+	assert.NoError(t, err)
+	parts, err := ParseKey(call.key)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 5, 1, 0, 0, 0, 0, shanghai).UnixMilli(), parts.Window)
+	ttl := client.PTTL(ctx, call.key).Val()
+	assert.Greater(t, ttl, time.Duration(0))
+	assert.LessOrEqual(t, ttl, time.Second)
+
+	state, err := obj.Inspect(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), state.Count)
+	assert.True(t, state.LastRefill.Equal(time.Date(2024, 5, 1, 0, 0, 0, 0, shanghai)))
+	assert.Equal(t, time.Second, state.ResetAt.Sub(now))
+
+	mu.Lock()
+	now = now.Add(time.Second)
+	mu.Unlock()
+	res, err = obj.Do()
+	assert.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, int64(2), res.Remaining)
+
+	// 日历配额可作为组合限流的规则
+	composite, err := NewComposite(
+		Rule{Name: "daily", Limiter: obj},
+		Rule{Name: "burst", Limiter: cli.NewRateLimiter(product, FixedWindowType, NewFixedWindowOption(10, 1))},
+	)
+	assert.NoError(t, err)
+	ret, err := composite.AllowN(ctx, 2)
+	assert.NoError(t, err)
+	assert.True(t, ret.Allowed)
+	ret, err = composite.Allow(ctx)
+	assert.NoError(t, err)
+	assert.False(t, ret.Allowed)
+	assert.Equal(t, "daily", ret.RejectedBy)
+
+	assert.NoError(t, obj.Reset(ctx))
+	assert.Equal(t, int64(0), client.Exists(ctx, call.key).Val())
+}
+
+// go test . -v -run=TestLimiter_Refund
+func TestLimiter_Refund(t *testing.T) {
+	tests := []struct {
+		name        string
+		limiterType LimiterType
+		options     Options
+	}{
+		{"固定窗口", FixedWindowType, NewFixedWindowOption(3, 10)},
+		{"滑动窗口", SlideWindowType, NewSlideWindowOption(3, 10)},
+		{"令牌桶", TokenBucketType, NewTokenBucketOption(3, 10, 3)},
+		{"漏桶", LeakyBucketType, NewLeakyBucketOption(3, 1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			product := fmt.Sprintf("test_refund_%d", time.Now().UnixNano())
+			obj := NewRateLimiter(product, tt.limiterType, tt.options)
+			ctx := context.TODO()
+
+			charged, err := obj.AllowN(ctx, 3)
+			assert.NoError(t, err)
+			assert.True(t, charged.Allowed)
+			assert.NotEmpty(t, charged.Key)
+
+			res, err := obj.Do()
+			assert.NoError(t, err)
+			assert.False(t, res.Allowed)
+			assert.Empty(t, res.Key)
+
+			// 归还后可以再次消耗, 但不会超过归还的数量
+			assert.NoError(t, obj.Refund(ctx, &charged, 2))
+			again, err := obj.AllowN(ctx, 2)
+			assert.NoError(t, err)
+			assert.True(t, again.Allowed)
+
+			res, err = obj.Do()
+			assert.NoError(t, err)
+			assert.False(t, res.Allowed)
+
+			// 被拒绝的决策未扣减许可, 不能归还
+			assert.ErrorIs(t, obj.Refund(ctx, &res, 3), ErrInvalidOptions)
+			res, err = obj.Do()
+			assert.NoError(t, err)
+			assert.False(t, res.Allowed)
+
+			// 归还数量超过决策剩余可归还的数量时按剩余数量归还, 之后不能重复归还
+			assert.NoError(t, obj.Refund(ctx, &charged, 10))
+			assert.ErrorIs(t, obj.Refund(ctx, &charged, 1), ErrInvalidOptions)
+			res, err = obj.Do()
+			assert.NoError(t, err)
+			assert.True(t, res.Allowed)
+			assert.Equal(t, int64(0), res.Remaining)
+		})
+	}
+
+	t.Run("归还数量不超过决策消耗的数量", func(t *testing.T) {
+		product := fmt.Sprintf("test_refund_cap_%d", time.Now().UnixNano())
+		obj := NewRateLimiter(product, FixedWindowType, NewFixedWindowOption(5, 3600))
+		ctx := context.TODO()
+
+		var last Decision
+		for i := 0; i < 5; i++ {
+			var err error
+			last, err = obj.Do()
+			assert.NoError(t, err)
+			assert.True(t, last.Allowed)
+		}
+
+		// 只消耗了一个许可的决策不能归还其他调用方的用量
+		assert.NoError(t, obj.Refund(ctx, &last, 5))
+		state, err := obj.Inspect(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(4), state.Count)
+		assert.Equal(t, int64(1), state.Remaining)
+	})
+
+	t.Run("窗口滚动后归还到原窗口", func(t *testing.T) {
+		now := time.Now().Truncate(time.Minute)
+		cli := New(client, WithClock(func() time.Time { return now }))
+		defer cli.Close()
+
+		product := fmt.Sprintf("test_refund_window_%d", time.Now().UnixNano())
+		obj, err := cli.NewLimiter(product, FixedWindowType, WithLimit(2), WithWindow(time.Minute))
+		assert.NoError(t, err)
+		ctx := context.TODO()
+
+		previous, err := obj.Do()
+		assert.NoError(t, err)
+		assert.True(t, previous.Allowed)
+
+		now = now.Add(time.Minute)
+		res, err := obj.AllowN(ctx, 2)
+		assert.NoError(t, err)
+		assert.True(t, res.Allowed)
+		assert.NotEqual(t, previous.Key, res.Key)
+
+		// 归还上一个窗口的许可不影响当前窗口
+		assert.NoError(t, obj.Refund(ctx, &previous, 1))
+		res, err = obj.Do()
+		assert.NoError(t, err)
+		assert.False(t, res.Allowed)
+	})
+}
+
+// go test . -v -run=TestLimiter_Inspect
+func TestLimiter_Inspect(t *testing.T) {
+	tests := []struct {
+		name        string
+		limiterType LimiterType
+		options     Options
+	}{
+		{"固定窗口", FixedWindowType, NewFixedWindowOption(5, 3600)},
+		{"滑动窗口", SlideWindowType, NewSlideWindowOption(5, 10)},
+		{"令牌桶", TokenBucketType, NewTokenBucketOption(5, 10, 5)},
+		{"漏桶", LeakyBucketType, NewLeakyBucketOption(5, 1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			product := fmt.Sprintf("test_inspect_%d", time.Now().UnixNano())
+			obj := NewRateLimiter(product, tt.limiterType, tt.options)
+			ctx := context.TODO()
+
+			state, err := obj.Inspect(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, int64(5), state.Remaining)
+
+			_, err = obj.AllowN(ctx, 2)
+			assert.NoError(t, err)
+
+			state, err = obj.Inspect(ctx)
+			assert.NoError(t, err)
+			t.Logf("state[%+v]", state)
+			assert.Equal(t, int64(5), state.Limit)
+			assert.Equal(t, state.Limit, state.Count+state.Remaining)
+			assert.LessOrEqual(t, state.Count, int64(2))
+
+			// 查询不会消耗许可
+			again, err := obj.Inspect(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, state.Remaining, again.Remaining)
+		})
+	}
+}
+
+// go test . -race -v -run=TestLimiter_Concurrent
+func TestLimiter_Concurrent(t *testing.T) {
+	tests := []struct {
+		name        string
+		limiterType LimiterType
+		options     Options
+	}{
+		{"FixedWindow", FixedWindowType, NewFixedWindowOption(50, 3600)},
+		{"SlideWindow", SlideWindowType, NewSlideWindowOption(50, 3600)},
+		{"TokenBucket", TokenBucketType, NewTokenBucketOption(50, 3600, 50)},
+		{"LeakyBucket", LeakyBucketType, NewLeakyBucketOption(50, 1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 启动时配置一次, 多个协程共享同一个限流器
+			product := fmt.Sprintf("test_concurrent_%d", time.Now().UnixNano())
+			obj := NewRateLimiter(product, tt.limiterType, tt.options)
+
+			var (
+				wg      sync.WaitGroup
+				allowed int64
+			)
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 10; j++ {
+						res, err := obj.AllowN(context.TODO(), 1)
+						assert.NoError(t, err)
+						if res.Allowed {
+							atomic.AddInt64(&allowed, 1)
+						}
+						_, err = obj.Inspect(context.TODO())
+						assert.NoError(t, err)
+					}
+				}()
+			}
+			wg.Wait()
+
+			// 漏桶在执行期间可能漏出少量水量
+			assert.GreaterOrEqual(t, allowed, int64(50))
+			assert.LessOrEqual(t, allowed, int64(55))
+		})
+	}
+}
+
+// go test . -v -run=TestClient
+func TestClient(t *testing.T) {
+	ctx := context.TODO()
+	product := fmt.Sprintf("test_client_%d", time.Now().UnixNano())
+
+	// 两个客户端使用不同的 Key 前缀及折叠代码标记, 状态与限流记录互不影响
+	cli1 := New(client, WithKeyPrefix("svc1"))
+	defer cli1.Close()
+	cli2 := New(client, WithKeyPrefix("svc2"), WithCompress(true), WithRecordBuffer(10))
+	defer cli2.Close()
+	assert.NotEqual(t, cli1.ScriptShas(), cli2.ScriptShas())
+
+	handler1, handler2 := NewLogHandler(), NewLogHandler()
+	cli1.RegisterHandler("client", handler1)
+	cli2.RegisterHandler("client", handler2)
+
+	obj1 := cli1.NewRateLimiter(product, FixedWindowType, NewFixedWindowOption(1, 3600))
+	obj2 := cli2.NewRateLimiter(product, FixedWindowType, NewFixedWindowOption(1, 3600))
+	assert.True(t, strings.HasPrefix(obj1.GetRedisKey(), "svc1::"))
+	assert.True(t, strings.HasPrefix(obj2.GetRedisKey(), "svc2::"))
+
+	res, err := obj1.Do()
+	assert.NoError(t, err)
+	assert.True(t, res.Allowed)
+	res, err = obj2.Do()
+	assert.NoError(t, err)
+	assert.True(t, res.Allowed)
+	res, err = obj1.Do()
+	assert.NoError(t, err)
+	assert.False(t, res.Allowed)
+
+	// 等待异步处理完成
+	time.Sleep(100 * time.Millisecond)
+	assert.Len(t, handler1.GetRecords(), 2)
+	assert.Len(t, handler2.GetRecords(), 1)
+
+	// 重置只影响所属客户端
+	assert.NoError(t, cli1.ResetProduct(ctx, product, FixedWindowType))
+	state, err := obj1.Inspect(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), state.Count)
+	state, err = obj2.Inspect(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), state.Count)
+}
+
+// go test . -v -run=TestLimiter_Reset
+func TestLimiter_Reset(t *testing.T) {
+	ctx := context.TODO()
+	product := fmt.Sprintf("test_reset_%d", time.Now().UnixNano())
+
+	// 限流器重置后立即恢复
+	obj := NewRateLimiter(product, FixedWindowType, NewFixedWindowOption(1, 3600))
+	res, err := obj.Do()
+	assert.NoError(t, err)
+	assert.True(t, res.Allowed)
+	res, err = obj.Do()
+	assert.NoError(t, err)
+	assert.False(t, res.Allowed)
+
+	// 未设置维度的限流器重置时保留按维度派生的 Key
+	user := NewRateLimiter(product, FixedWindowType, NewFixedWindowOption(1, 3600)).WithDimension(DimUser, "u1")
+	res, err = user.Do()
+	assert.NoError(t, err)
+	assert.True(t, res.Allowed)
+
+	assert.NoError(t, obj.Reset(ctx))
+	res, err = obj.Do()
+	assert.NoError(t, err)
+	assert.True(t, res.Allowed)
+	res, err = user.Do()
+	assert.NoError(t, err)
+	assert.False(t, res.Allowed)
+
+	// 删除全部时间窗口及分片, 但保留名称以 "::" 延伸的其他业务线
+	prefix := RedisKeyPrefix + "::" + string(FixedWindowType) + "::" + product
+	keep := prefix + "::sub::100::0"
+	for _, key := range []string{prefix + "::100::0", prefix + "::101::1", keep} {
+		assert.NoError(t, client.Set(ctx, key, 1, time.Minute).Err())
+	}
+
+	assert.NoError(t, ResetProduct(ctx, product, FixedWindowType))
+	keys, err := client.Keys(ctx, escapeGlobPattern(prefix)+"*").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{keep}, keys)
+}
+
+// go test . -v -run=TestKeyBuilder
+func TestKeyBuilder(t *testing.T) {
+	base := NewKeyBuilder(SlideWindowType, "credit")
+	b := base.Tenant("t1").User("1001").Route("/api/v1::order")
+	assert.Equal(t, "dlimiter::SlideWindow::credit", base.Build())
+	assert.Equal(t, "dlimiter::SlideWindow::credit::tenant=t1::user=1001::route=/api/v1%3A%3Aorder", b.Build())
+
+	// 同名维度原位替换, 不影响基础构造器
+	assert.Equal(t, "dlimiter::SlideWindow::credit::tenant=t1::user=1002::route=/api/v1%3A%3Aorder", b.User("1002").Build())
+	assert.Empty(t, base.Dimensions())
+
+	// 各部分的分隔符均可还原
+	tricky := NewKeyBuilder(FixedWindowType, "a::b=c%3A").WithPrefix("svc:1").With("k=v", "x::y").IP("::1")
+	got, err := ParseKey(tricky.Build())
+	assert.NoError(t, err)
+	assert.Equal(t, KeyParts{
+		Prefix:     "svc:1",
+		Type:       FixedWindowType,
+		Product:    "a::b=c%3A",
+		Dimensions: []KeyDimension{{Name: "k=v", Value: "x::y"}, {Name: DimIP, Value: "::1"}},
+	}, got)
+
+	// 解析限流器生成的带窗口/分片后缀的 Key
+	got, err = ParseKey(b.Build() + "::3")
+	assert.NoError(t, err)
+	assert.Equal(t, "credit", got.Product)
+	assert.Equal(t, b.Dimensions(), got.Dimensions)
+	assert.True(t, got.HasSuffix)
+	assert.Equal(t, int64(3), got.Shard)
+
+	got, err = ParseKey("dlimiter::FixedWindow::credit::user=1::28800::1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(28800), got.Window)
+	assert.Equal(t, int64(1), got.Shard)
+
+	for _, key := range []string{
+		"dlimiter::credit",
+		"dlimiter::Unknown::credit",
+		"dlimiter::FixedWindow::credit::28800",
+		"dlimiter::TokenBucket::credit::user=1::x",
+		"dlimiter::TokenBucket::credit::0::user=1",
+	} {
+		_, err := ParseKey(key)
+		assert.ErrorIs(t, err, ErrInvalidKey, key)
+	}
+}
+
+// go test . -v -run=TestLimiter_Dimension
+func TestLimiter_Dimension(t *testing.T) {
+	ctx := context.TODO()
+	product := fmt.Sprintf("test_dimension_%d", time.Now().UnixNano())
+
+	newLimiter := func(user string) *RateLimiter {
+		return NewRateLimiter(product, TokenBucketType, NewTokenBucketOption(1, 3600, 1)).WithDimension(DimUser, user)
+	}
+	u1, u2 := newLimiter("u1"), newLimiter("u2")
+
+	// 不同维度取值分别限流
+	for _, obj := range []*RateLimiter{u1, u2} {
+		res, err := obj.Do()
+		assert.NoError(t, err)
+		assert.True(t, res.Allowed)
+		res, err = obj.Do()
+		assert.NoError(t, err)
+		assert.False(t, res.Allowed)
+	}
+
+	parts, err := ParseKey(u1.GetRedisKey())
+	assert.NoError(t, err)
+	assert.Equal(t, product, parts.Product)
+	assert.Equal(t, []KeyDimension{{Name: DimUser, Value: "u1"}}, parts.Dimensions)
+
+	// 按维度重置只影响该取值
+	assert.NoError(t, u1.Reset(ctx))
+	res, err := u1.Do()
+	assert.NoError(t, err)
+	assert.True(t, res.Allowed)
+	res, err = u2.Do()
+	assert.NoError(t, err)
+	assert.False(t, res.Allowed)
+
+	// 按业务线重置覆盖全部维度
+	assert.NoError(t, ResetProduct(ctx, product, TokenBucketType))
+	res, err = u2.Do()
+	assert.NoError(t, err)
+	assert.True(t, res.Allowed)
+}
+
+// go test . -v -run=TestPolicy
+func TestPolicy(t *testing.T) {
+	ctx := context.TODO()
+	product := fmt.Sprintf("test_policy_%d", time.Now().UnixNano())
+
+	_, err := NewPolicy(product, SlideWindowType, WithLimit(2))
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+
+	policy, err := NewPolicy(product, SlideWindowType, WithRate(2, time.Hour))
+	assert.NoError(t, err)
+	policy.WithDimension(DimUser)
+
+	// 每个主体分别限流
+	for _, subject := range []string{"u1", "u2"} {
+		for i := 0; i < 2; i++ {
+			res, err := policy.Allow(ctx, subject)
+			assert.NoError(t, err)
+			assert.True(t, res.Allowed)
+		}
+		res, err := policy.Allow(ctx, subject)
+		assert.NoError(t, err)
+		assert.False(t, res.Allowed)
+	}
+
+	_, err = policy.Allow(ctx, "")
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+
+	// 主体对应的限流器与策略共用同一个 Key
+	u1 := policy.Limiter("u1")
+	parts, err := ParseKey(u1.GetRedisKey())
+	assert.NoError(t, err)
+	assert.Equal(t, []KeyDimension{{Name: DimUser, Value: "u1"}}, parts.Dimensions)
+
+	state, err := u1.Inspect(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), state.Remaining)
+
+	assert.NoError(t, u1.Reset(ctx))
+	res, err := policy.Allow(ctx, "u1")
+	assert.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, u1.GetRedisKey(), res.Key)
+
+	// 按 Key 前缀拼接的存储Key与 Key 构造器生成的一致, 主体取值同样转义
+	call := policy.newCall(time.Now(), "a::b=c")
+	assert.Equal(t, policy.keyBuilder("a::b=c").Build(), call.baseKey)
+	assert.Equal(t, policy.Limiter("a::b=c").GetRedisKey(), call.key)
+	res, err = policy.Allow(ctx, "u2")
+	assert.NoError(t, err)
+	assert.False(t, res.Allowed)
+
+	// 并发复用同一个策略
+	var allowed int64
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			res, err := policy.AllowN(ctx, fmt.Sprintf("c%d", i%2), 1)
+			assert.NoError(t, err)
+			if res.Allowed {
+				atomic.AddInt64(&allowed, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, int64(4), allowed)
+}
+
+// go test . -v -run=TestLimiterInterface
+func TestLimiterInterface(t *testing.T) {
+	ctx := context.TODO()
+	product := fmt.Sprintf("test_interface_%d", time.Now().UnixNano())
+
+	tests := []struct {
+		name    string
+		limiter Limiter
+		allowed bool
+	}{
+		{"Noop", NoopLimiter{}, true},
+		{"DenyAll", DenyAllLimiter{}, false},
+		{"RateLimiter", NewRateLimiter(product, TokenBucketType, NewTokenBucketOption(1, 3600, 1)), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := tt.limiter.Allow(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.allowed, res.Allowed)
+
+			_, err = tt.limiter.AllowN(ctx, 0)
+			assert.ErrorIs(t, err, ErrInvalidOptions)
+
+			if tt.allowed {
+				assert.NoError(t, tt.limiter.Reset(ctx))
+				assert.NoError(t, tt.limiter.Wait(ctx))
+			} else {
+				assert.Equal(t, time.Duration(-1), res.RetryAfter)
+				assert.ErrorIs(t, tt.limiter.Wait(ctx), ErrLimited)
+			}
+
+			_, err = tt.limiter.Inspect(ctx)
+			assert.NoError(t, err)
+		})
+	}
+}
+
+// go test . -v -run=TestComposite
+func TestComposite(t *testing.T) {
+	ctx := context.TODO()
+	product := fmt.Sprintf("test_composite_%d", time.Now().UnixNano())
+
+	newLimiter := func(limiterType LimiterType, opts ...Option) *RateLimiter {
+		obj, err := NewLimiter(product, limiterType, opts...)
+		assert.NoError(t, err)
+		return obj
+	}
+	user := newLimiter(TokenBucketType, WithBurst(5), WithRate(1, time.Hour)).WithDimension(DimUser, "u1")
+	tenant := newLimiter(FixedWindowType, WithLimit(2), WithWindow(time.Hour)).WithDimension(DimTenant, "t1")
+	global := newLimiter(SlideWindowType, WithLimit(10), WithWindow(time.Hour))
+	queue := newLimiter(LeakyBucketType, WithBurst(5), WithRate(1, time.Hour))
+
+	composite, err := NewComposite(
+		Rule{Name: "user", Limiter: user},
+		Rule{Name: "tenant", Limiter: tenant},
+		Rule{Name: "global", Limiter: global},
+		Rule{Name: "queue", Limiter: queue},
+	)
+	assert.NoError(t, err)
+	defer composite.Reset(ctx)
+
+	for i := 0; i < 2; i++ {
+		res, err := composite.Allow(ctx)
+		assert.NoError(t, err)
+		assert.True(t, res.Allowed)
+		assert.Empty(t, res.RejectedBy)
+		// 剩余可用请求数取最严格的规则
+		assert.Equal(t, int64(2), res.Limit)
+		assert.Equal(t, int64(1-i), res.Remaining)
+	}
+
+	// 租户规则拒绝时其他规则均不消耗
+	res, err := composite.Allow(ctx)
+	assert.NoError(t, err)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, "tenant", res.RejectedBy)
+	assert.Greater(t, res.RetryAfter, time.Duration(0))
+	assert.Len(t, res.Rules, 4)
+	assert.True(t, res.Rules[0].Decision.Allowed)
+	assert.False(t, res.Rules[1].Decision.Allowed)
+
+	for _, tt := range []struct {
+		limiter   *RateLimiter
+		remaining int64
+	}{{user, 3}, {global, 8}, {queue, 3}} {
+		state, err := tt.limiter.Inspect(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.remaining, state.Remaining, tt.limiter.GetRedisKey())
+	}
+
+	// 单次消耗超过规则上限时永远无法满足
+	res, err = composite.AllowN(ctx, 6)
+	assert.NoError(t, err)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, "user", res.RejectedBy)
+	assert.Less(t, res.RetryAfter, time.Duration(0))
+
+	// 参数校验
+	_, err = NewComposite()
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+	_, err = NewComposite(Rule{Name: "a", Limiter: user}, Rule{Name: "a", Limiter: tenant})
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+	_, err = NewComposite(Rule{Name: "a", Limiter: user}, Rule{Name: "b", Limiter: New(nil).NewRateLimiter(product, FixedWindowType, NewFixedWindowOption(1, 1))})
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+
+	// 组合限流中无法生效的设置
+	list, err := NewAccessList("u1")
+	assert.NoError(t, err)
+	for _, limiter := range []*RateLimiter{
+		newLimiter(FixedWindowType, WithLimit(1), WithWindow(time.Hour)).WithFailurePolicy(FailOpen),
+		newLimiter(FixedWindowType, WithLimit(1), WithWindow(time.Hour)).WithDryRun(true),
+		newLimiter(FixedWindowType, WithLimit(1), WithWindow(time.Hour)).WithAllowList(list),
+		newLimiter(FixedWindowType, WithLimit(1), WithWindow(time.Hour)).WithPenalty(Penalty{Threshold: 1, Period: time.Second, Ban: time.Second}),
+	} {
+		_, err = NewComposite(Rule{Name: "a", Limiter: user}, Rule{Name: "b", Limiter: limiter})
+		assert.ErrorIs(t, err, ErrUnsupported)
+	}
+
+	shared, err := NewComposite(Rule{Name: "a", Limiter: global}, Rule{Name: "b", Limiter: newLimiter(SlideWindowType, WithLimit(1), WithWindow(time.Hour))})
+	assert.NoError(t, err)
+	_, err = shared.Allow(ctx)
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+}
+
+// go test . -v -run=TestHierarchy
+func TestHierarchy(t *testing.T) {
+	ctx := context.TODO()
+	product := fmt.Sprintf("test_hierarchy_%d", time.Now().UnixNano())
+
+	_, err := NewHierarchy(product, Level{Name: "org", LimiterType: SlideWindowType, Options: []Option{WithLimit(5)}})
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+
+	h, err := NewHierarchy(product,
+		Level{Name: "org", LimiterType: SlideWindowType, Options: []Option{WithLimit(5), WithWindow(time.Hour)}},
+		Level{Name: "project", LimiterType: TokenBucketType, Options: []Option{WithBurst(4), WithRate(1, time.Hour)}},
+		Level{Name: "user", LimiterType: FixedWindowType, Options: []Option{WithLimit(2), WithWindow(time.Hour)}},
+	)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		path       []string
+		allowed    bool
+		remaining  int64
+		rejectedBy string
+	}{
+		{[]string{"o1", "p1", "u1"}, true, 1, ""},
+		{[]string{"o1", "p1", "u1"}, true, 0, ""},
+		{[]string{"o1", "p1", "u1"}, false, 0, "user"},
+		{[]string{"o1", "p1", "u2"}, true, 1, ""},
+		{[]string{"o1", "p1", "u2"}, true, 0, ""},
+		{[]string{"o1", "p1", "u3"}, false, 0, "project"},
+		{[]string{"o1", "p2", "u3"}, true, 0, ""},
+		{[]string{"o1", "p2", "u4"}, false, 0, "org"},
+		{[]string{"o2", "p1", "u1"}, true, 1, ""},
+	}
+	for i, tt := range tests {
+		res, err := h.Allow(ctx, tt.path...)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.allowed, res.Allowed, i)
+		assert.Equal(t, tt.remaining, res.Remaining, i)
+		assert.Equal(t, tt.rejectedBy, res.RejectedBy, i)
+	}
+
+	// 各层级的 Key 包含上级路径, 拒绝时上级不消耗
+	org, err := h.Limiter("o1")
+	assert.NoError(t, err)
+	state, err := org.Inspect(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), state.Remaining)
+
+	project, err := h.Limiter("o1", "p2")
+	assert.NoError(t, err)
+	parts, err := ParseKey(project.GetRedisKey())
+	assert.NoError(t, err)
+	assert.Equal(t, []KeyDimension{{Name: "org", Value: "o1"}, {Name: "project", Value: "p2"}}, parts.Dimensions)
+	state, err = project.Inspect(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), state.Remaining)
+
+	// 解除组织级限流后下级恢复
+	assert.NoError(t, org.Reset(ctx))
+	res, err := h.Allow(ctx, "o1", "p2", "u4")
+	assert.NoError(t, err)
+	assert.True(t, res.Allowed)
+
+	_, err = h.Allow(ctx, "o1", "p1")
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+	_, err = h.Allow(ctx, "o1", "", "u1")
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+	_, err = h.Limiter()
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+}
+
 // 令牌桶 - 根据时间顺序有序发放
 //
 // 根据下面命令可得出: 每次令牌发放间隔相同
@@ -368,6 +1987,128 @@ func TestLimiter_TokenBucketLimiter_6(t *testing.T) {
 	}
 }
 
+// go test . -v -run=TestLimiter_RejectNoWrite
+func TestLimiter_RejectNoWrite(t *testing.T) {
+	tests := []struct {
+		name        string
+		limiterType LimiterType
+		opts        []Option
+	}{
+		{"固定窗口", FixedWindowType, []Option{WithLimit(2), WithWindow(time.Minute)}},
+		{"滑动窗口", SlideWindowType, []Option{WithLimit(2), WithWindow(time.Minute)}},
+		{"令牌桶", TokenBucketType, []Option{WithBurst(2), WithRate(1, time.Second)}},
+		{"漏桶", LeakyBucketType, []Option{WithBurst(2), WithRate(1, time.Second)}},
+	}
+
+	now := time.Now()
+	cli := New(client, WithClock(func() time.Time { return now }))
+	defer cli.Close()
+
+	ctx := context.TODO()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			product := fmt.Sprintf("test_reject_%d", time.Now().UnixNano())
+			obj, err := cli.NewLimiter(product, tt.limiterType, tt.opts...)
+			assert.NoError(t, err)
+
+			res, err := obj.AllowN(ctx, 2)
+			assert.NoError(t, err)
+			assert.True(t, res.Allowed)
+			before := client.Dump(ctx, res.Key).Val()
+
+			// 单一限流器与组合限流共用校验函数, 拒绝时不写入任何状态
+			now = now.Add(100 * time.Millisecond)
+			rejected, err := obj.Do()
+			assert.NoError(t, err)
+			assert.False(t, rejected.Allowed)
+			assert.Equal(t, before, client.Dump(ctx, res.Key).Val())
+		})
+	}
+}
+
+// go test . -v -run=TestLimiter_TokenBucketInitTokens
+func TestLimiter_TokenBucketInitTokens(t *testing.T) {
+	tests := []struct {
+		name       string
+		initTokens int64
+		cost       int64
+		composite  bool
+	}{
+		{"初始无令牌", 0, 1, false},
+		{"消耗超过初始令牌", 1, 2, false},
+		{"组合-初始无令牌", 0, 1, true},
+		{"组合-消耗超过初始令牌", 1, 2, true},
+	}
+
+	now := time.Now()
+	cli := New(client, WithClock(func() time.Time { return now }))
+	defer cli.Close()
+
+	ctx := context.TODO()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			product := fmt.Sprintf("test_token_init_%d", time.Now().UnixNano())
+			// 每 500ms 生成一个令牌
+			obj := cli.NewRateLimiter(product, TokenBucketType, NewTokenBucketOption(2, 1, tt.initTokens))
+			composite, err := NewComposite(Rule{Name: "token", Limiter: obj})
+			assert.NoError(t, err)
+
+			// 新建的桶被拒绝时也保存填充时间, 令牌随时间累计, 第三次请求(600ms)时放行
+			for i := 0; i < 3; i++ {
+				var res Decision
+				if tt.composite {
+					ret, err := composite.AllowN(ctx, tt.cost)
+					assert.NoError(t, err)
+					res = ret.Decision
+				} else {
+					res, err = obj.AllowN(ctx, tt.cost)
+					assert.NoError(t, err)
+				}
+				assert.Equal(t, i == 2, res.Allowed, "call %d", i)
+				now = now.Add(300 * time.Millisecond)
+			}
+		})
+	}
+
+	t.Run("闲置重置", func(t *testing.T) {
+		product := fmt.Sprintf("test_token_init_%d", time.Now().UnixNano())
+		obj := cli.NewRateLimiter(product, TokenBucketType, NewTokenBucketOption(2, 1, 0))
+
+		// 闲置超过重置间隔后按初始令牌数重建, 同样需要保存填充时间
+		res, err := obj.Do()
+		assert.NoError(t, err)
+		assert.False(t, res.Allowed)
+		now = now.Add(600 * time.Millisecond)
+		res, err = obj.Do()
+		assert.NoError(t, err)
+		assert.True(t, res.Allowed)
+
+		now = now.Add(5 * time.Second)
+		for i := 0; i < 3; i++ {
+			res, err = obj.Do()
+			assert.NoError(t, err)
+			assert.Equal(t, i == 2, res.Allowed, "call %d", i)
+			now = now.Add(300 * time.Millisecond)
+		}
+	})
+}
+
+func TestLimiter_TokenBucketTTL(t *testing.T) {
+	product := fmt.Sprintf("test_token_ttl_%d", time.Now().UnixNano())
+	obj, err := NewLimiter(product, TokenBucketType, WithBurst(10), WithRate(10, time.Second), WithTTL(2*time.Second))
+	assert.NoError(t, err)
+
+	ctx := context.TODO()
+	for i := 0; i < 2; i++ {
+		// 每次写入都刷新过期时间, 持续访问的桶不会过期重建
+		assert.NoError(t, client.PExpire(ctx, obj.GetRedisKey(), 100*time.Millisecond).Err())
+		res, err := obj.Do()
+		assert.NoError(t, err)
+		assert.True(t, res.Allowed)
+		assert.InDelta(t, 2000, client.PTTL(ctx, obj.GetRedisKey()).Val().Milliseconds(), 50)
+	}
+}
+
 func TestLimiter_LeakyBucketLimiter(t *testing.T) {
 	sha, err := LoadScript(context.TODO(), client, luaScriptMap["LeakyBucketScript"])
 	if err != nil {
@@ -394,7 +2135,6 @@ func TestLeakyBucket(t *testing.T) {
 		capacity int64
 		leakRate int64
 		requests int
-		interval time.Duration
 		want     int
 	}{
 		{
@@ -402,7 +2142,6 @@ func TestLeakyBucket(t *testing.T) {
 			capacity: 5,
 			leakRate: 1,
 			requests: 10,
-			interval: time.Second,
 			want:     5,
 		},
 		{
@@ -410,24 +2149,28 @@ func TestLeakyBucket(t *testing.T) {
 			capacity: 3,
 			leakRate: 5,
 			requests: 10,
-			interval: time.Millisecond * 100,
 			want:     3,
 		},
 	}
 
+	// 固定时钟, 请求期间不漏水, 通过请求数不超过桶容量
+	now := time.Now()
+	cli := New(client, WithClock(func() time.Time { return now }))
+	defer cli.Close()
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			obj := NewRateLimiter("test", LeakyBucketType)
+			product := fmt.Sprintf("test_leaky_%d", time.Now().UnixNano())
+			obj := cli.NewRateLimiter(product, LeakyBucketType)
 			passed := 0
 
 			for i := 0; i < tt.requests; i++ {
 				rr, err := obj.WithOption(NewLeakyBucketOption(tt.capacity, tt.leakRate)).Do()
 
-				t.Logf("result: %v, err: %v", rr, err)
-				if rr > 0 {
+				t.Logf("result: %+v, err: %v", rr, err)
+				if rr.Allowed {
 					passed++
 				}
-				time.Sleep(tt.interval)
 			}
 
 			if passed > tt.want {
@@ -474,3 +2217,33 @@ func BenchmarkLimiter_SlideWindowLimiter(b *testing.B) {
 		}(int(curtime) + i)
 	}
 }
+
+// go test . -run=^$ -bench=BenchmarkPolicy -benchmem
+func BenchmarkPolicy_NewCall(b *testing.B) {
+	policy, err := NewPolicy("bench_policy", FixedWindowType, WithLimit(100), WithWindow(time.Minute))
+	if err != nil {
+		b.Fatal(err)
+	}
+	policy.WithDimension(DimUser)
+
+	now := time.Now()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = policy.newCall(now, "u1")
+	}
+}
+
+func BenchmarkPolicy_Allow(b *testing.B) {
+	policy, err := NewPolicy(fmt.Sprintf("bench_policy_%d", time.Now().UnixNano()), SlideWindowType, WithRate(1<<30, time.Minute))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	ctx := context.TODO()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := policy.Allow(ctx, "u1"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+// Copyright(C) 2024 Github Inc. All Rights Reserved.
+// Author: metrue8@gmail.com
+// Date:   2024/01/03
+
+package ratelimiter
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// defaultHealthCheckInterval 本地限流期间检查 Redis 是否恢复的默认间隔
+const defaultHealthCheckInterval = time.Second
+
+// localFallback 进程内本地限流器, Redis 不可用时接管 FallbackLocal 策略的限流决策
+//
+// 首次遇到 Redis 执行失败时自动启用, 启用期间 FallbackLocal 策略的限流器不再访问 Redis;
+// 后台按间隔执行 PING, Redis 恢复后切回 Redis 限流并清空本地状态. 启用与恢复均通过限流记录通道上报
+type localFallback struct {
+	client    *Client                 // 所属客户端
+	mutex     sync.Mutex              // 保护以下字段
+	active    bool                    // 是否已启用本地限流
+	buckets   map[string]*localBucket // 存储Key(不含分片后缀) => 本地限流状态
+	done      chan struct{}           // 关闭信号
+	closeOnce sync.Once               // 保证只关闭一次
+}
+
+// localBucket 单个存储Key的本地限流状态
+type localBucket struct {
+	window   int64       // 固定窗口序号 / 日历周期开始时间(ms)
+	count    float64     // 固定窗口计数 / 令牌桶令牌数 / 漏桶水量
+	last     int64       // 令牌桶上次填充时间 / 漏桶上次漏水时间(ms), 为0表示未初始化
+	slots    []localSlot // 滑动窗口小格子, 按时间升序
+	expireAt int64       // 状态过期时间(ms), 过期后由健康检查协程清除
+}
+
+// localSlot 滑动窗口小格子
+type localSlot struct {
+	time  int64 // 小格子时间
+	count int64 // 小格子内的请求数
+}
+
+// newLocalFallback 创建本地限流器
+func newLocalFallback(c *Client) *localFallback {
+	return &localFallback{
+		client:  c,
+		buckets: make(map[string]*localBucket),
+		done:    make(chan struct{}),
+	}
+}
+
+// isActive 是否已启用本地限流
+func (l *localFallback) isActive() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.active
+}
+
+// activate 启用本地限流并启动健康检查协程, 已启用或客户端已关闭时忽略
+func (l *localFallback) activate(err error) {
+	l.mutex.Lock()
+	if l.active || l.closed() {
+		l.mutex.Unlock()
+		return
+	}
+	l.active = true
+	l.mutex.Unlock()
+
+	l.client.recorder.send(LimiterRecord{
+		Timestamp: l.client.now(),
+		Error:     err,
+		Fallback:  FallbackLocal,
+		Event:     EventFallbackActivated,
+	})
+	go l.healthCheck()
+}
+
+// deactivate Redis 恢复后停用本地限流并清空本地状态
+func (l *localFallback) deactivate() {
+	l.mutex.Lock()
+	l.active = false
+	l.buckets = make(map[string]*localBucket)
+	l.mutex.Unlock()
+
+	l.client.recorder.send(LimiterRecord{
+		Timestamp: l.client.now(),
+		Event:     EventFallbackRecovered,
+	})
+}
+
+// close 停止健康检查协程
+func (l *localFallback) close() {
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
+}
+
+// closed 客户端是否已关闭
+func (l *localFallback) closed() bool {
+	select {
+	case <-l.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// healthCheck 按间隔 PING Redis, 成功后停用本地限流; 每次检查同时清除过期的本地状态
+func (l *localFallback) healthCheck() {
+	ticker := time.NewTicker(l.client.healthCheck)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), l.client.healthCheck)
+		err := l.client.rdb.Ping(ctx).Err()
+		cancel()
+		if err == nil {
+			l.deactivate()
+			return
+		}
+
+		l.sweep(l.client.now().UnixMilli())
+	}
+}
+
+// sweep 清除过期的本地状态
+func (l *localFallback) sweep(nowMs int64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for key, bucket := range l.buckets {
+		if bucket.expireAt <= nowMs {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// share 将限流大小按实例数均分, 向上取整; 原值大于0时至少为1
+func (l *localFallback) share(v int64) int64 {
+	if v <= 0 {
+		return v
+	}
+
+	return int64(math.Ceil(float64(v) / float64(l.client.instances)))
+}
+
+// allow 按限流器类型在本地执行限流, 各实例分摊限流大小及速率
+func (l *localFallback) allow(limiterType LimiterType, key string, opts Options, now time.Time, n int64) Decision {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &localBucket{}
+		l.buckets[key] = bucket
+	}
+
+	nowMs := now.UnixMilli()
+	var d Decision
+	var resetAfter int64
+	switch limiterType {
+	case FixedWindowType:
+		d, resetAfter = l.fixedWindow(bucket, opts.fixedWindowOptions, nowMs, n)
+	case SlideWindowType:
+		d, resetAfter = l.slideWindow(bucket, opts.slideWindowOptions, nowMs, n)
+	case TokenBucketType:
+		d, resetAfter = l.tokenBucket(bucket, opts.tokenBucketOptions, nowMs, n)
+	case LeakyBucketType:
+		d, resetAfter = l.leakyBucket(bucket, opts.leakyBucketOptions, nowMs, n)
+	case CalendarQuotaType:
+		w := opts.calendarQuotaOptions.window(now)
+		d, resetAfter = l.counter(bucket, l.share(opts.calendarQuotaOptions.limitCount), w.start.UnixMilli(), w.end.UnixMilli(), nowMs, n)
+	}
+
+	d.ResetAt = now.Add(time.Duration(resetAfter) * time.Millisecond)
+	d.Fallback = FallbackLocal
+	return d
+}
+
+// fixedWindow 本地固定窗口限流, 返回决策结果及距离窗口重置时间(ms)
+func (l *localFallback) fixedWindow(b *localBucket, o fixedWindowOptions, nowMs, n int64) (Decision, int64) {
+	window := nowMs / o.unitTime
+	return l.counter(b, l.share(o.limitCount), window, (window+1)*o.unitTime, nowMs, n)
+}
+
+// counter 本地窗口计数, 固定窗口及日历配额共用; 窗口变化时重新计数, end 为窗口结束时间(ms)
+func (l *localFallback) counter(b *localBucket, limit, window, end, nowMs, n int64) (Decision, int64) {
+	if b.window != window {
+		b.window, b.count = window, 0
+	}
+	b.expireAt = end
+	resetAfter := end - nowMs
+
+	count := int64(b.count)
+	if count+n > limit {
+		d := Decision{Limit: limit, Remaining: limit - count, RetryAfter: time.Duration(resetAfter) * time.Millisecond}
+		if n > limit {
+			d.RetryAfter = -1
+		}
+		return d, resetAfter
+	}
+
+	b.count += float64(n)
+	return Decision{Allowed: true, Limit: limit, Remaining: limit - count - n}, resetAfter
+}
+
+// slideWindow 本地滑动窗口限流, 小格子的划分与 Lua 脚本一致
+func (l *localFallback) slideWindow(b *localBucket, o slideWindowOptions, nowMs, n int64) (Decision, int64) {
+	limit := l.share(o.limitCount)
+	newTime, diffVal, littleWin := nowMs, o.unitTime, int64(1)
+	if o.unitTime > 1000 {
+		littleWin = int64(math.Ceil(float64(o.unitTime) / 1000))
+		newTime = nowMs / littleWin
+		diffVal = o.unitTime / littleWin
+	}
+
+	// 滑出窗口的小格子
+	slots := b.slots[:0]
+	var count int64
+	for _, slot := range b.slots {
+		if newTime-slot.time < diffVal {
+			slots = append(slots, slot)
+			count += slot.count
+		}
+	}
+	b.slots = slots
+	b.expireAt = nowMs + o.unitTime
+
+	if count+n > limit {
+		d := Decision{Limit: limit, Remaining: limit - count, RetryAfter: -1}
+		if n > limit || len(slots) == 0 {
+			return d, 0
+		}
+
+		var released int64
+		for _, slot := range slots {
+			released += slot.count
+			d.RetryAfter = time.Duration((slot.time+diffVal)*littleWin-nowMs) * time.Millisecond
+			if count-released+n <= limit {
+				break
+			}
+		}
+		return d, (slots[len(slots)-1].time+diffVal)*littleWin - nowMs
+	}
+
+	if last := len(b.slots) - 1; last >= 0 && b.slots[last].time == newTime {
+		b.slots[last].count += n
+	} else {
+		b.slots = append(b.slots, localSlot{time: newTime, count: n})
+	}
+	return Decision{Allowed: true, Limit: limit, Remaining: limit - count - n}, (newTime+diffVal)*littleWin - nowMs
+}
+
+// tokenBucket 本地令牌桶限流, 桶上限按实例数均分, 令牌产生间隔按实例数放大
+func (l *localFallback) tokenBucket(b *localBucket, o tokenBucketOptions, nowMs, n int64) (Decision, int64) {
+	intervalPerPermit, _, initTokens := tokenBucketParams(o)
+	maxTokens := l.share(o.maxTokens)
+	intervalPerPermit *= float64(l.client.instances)
+
+	if b.last == 0 {
+		b.count = float64(minInt64(l.share(initTokens), maxTokens))
+	} else if nowMs > b.last {
+		b.count = math.Min(float64(maxTokens), b.count+float64(nowMs-b.last)/intervalPerPermit)
+	}
+	if nowMs > b.last {
+		b.last = nowMs
+	}
+	b.expireAt = nowMs + int64(math.Ceil(float64(maxTokens)*intervalPerPermit))*2
+
+	d := Decision{Limit: maxTokens}
+	if b.count < float64(n) {
+		d.Remaining = int64(b.count)
+		d.RetryAfter = -1
+		if n <= maxTokens {
+			d.RetryAfter = time.Duration(math.Ceil((float64(n)-b.count)*intervalPerPermit)) * time.Millisecond
+		}
+	} else {
+		b.count -= float64(n)
+		d.Allowed, d.Remaining = true, int64(b.count)
+	}
+
+	return d, int64(math.Ceil((float64(maxTokens) - b.count) * intervalPerPermit))
+}
+
+// leakyBucket 本地漏桶限流, 桶容量及漏水速率按实例数均分
+func (l *localFallback) leakyBucket(b *localBucket, o leakyBucketOptions, nowMs, n int64) (Decision, int64) {
+	capacity := l.share(o.capacity)
+	leakRate := o.leakRate / float64(l.client.instances)
+
+	if b.last != 0 && nowMs > b.last {
+		b.count = math.Max(0, b.count-float64(nowMs-b.last)*leakRate/1000)
+	}
+	if nowMs > b.last {
+		b.last = nowMs
+	}
+	b.expireAt = nowMs + int64(math.Ceil(float64(capacity)*1000/leakRate))*2
+
+	d := Decision{Limit: capacity}
+	if b.count+float64(n) > float64(capacity) {
+		d.Remaining = capacity - int64(math.Ceil(b.count))
+		d.RetryAfter = -1
+		if n <= capacity {
+			d.RetryAfter = time.Duration(math.Ceil((b.count+float64(n)-float64(capacity))*1000/leakRate)) * time.Millisecond
+		}
+	} else {
+		b.count += float64(n)
+		d.Allowed, d.Remaining = true, capacity-int64(math.Ceil(b.count))
+	}
+
+	return d, int64(math.Ceil(b.count * 1000 / leakRate))
+}
+
+// minInt64 返回两者中的较小值
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+
+	return b
+}
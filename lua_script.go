@@ -6,14 +6,239 @@ package ratelimiter
 
 import (
 	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
 	"strings"
 )
 
 var luaScriptMap, luaScriptOptMap map[string]string
 
+// luaLimiterChecks 各限流算法的校验函数, 单一限流器脚本、组合限流及惩罚脚本共用同一份实现
+//
+// 每个函数依赖脚本中已定义的 cost、curTime、reserve(是否为预约模式, 1 为预约), 返回 {result = 5个决策字段, commit = 放行时写入状态的函数};
+// 拒绝时 commit 为空且不消耗许可; 仅令牌桶新建或闲置重置时即使拒绝也会保存填充时间, 见 checkTokenBucket
+const luaLimiterChecks = `
+		-- 计数器: 固定窗口及日历配额共用, 首次写入时设置过期时间
+		local function checkCounter(key, limit, resetAfter, expiration)
+			local current = tonumber(redis.call('GET', key) or "0")
+
+			if current + cost > limit then
+				local retryAfter = resetAfter
+				if cost > limit then
+					retryAfter = -1
+				end
+				return {result = {0, limit, math.max(0, limit - current), retryAfter, resetAfter}}
+			end
+
+			local commit = function()
+				if redis.call('INCRBY', key, cost) == cost then
+					redis.call('PEXPIRE', key, expiration)
+				end
+			end
+			return {result = {1, limit, limit - current - cost, 0, resetAfter}, commit = commit}
+		end
+
+		-- 固定窗口: 校验当前窗口计数
+		local function checkFixedWindow(key, limit, unitTime, expiration)
+			return checkCounter(key, limit, unitTime - math.fmod(curTime, unitTime), expiration)
+		end
+
+		-- 日历配额: 周期边界由调用方按时区计算, Key 在周期结束时过期
+		local function checkCalendarQuota(key, limit, resetAfter)
+			return checkCounter(key, limit, resetAfter, resetAfter)
+		end
+
+		-- 滑动窗口: 统计窗口内仍有效的小格子, 过期的小格子在写入时删除
+		local function checkSlideWindow(key, limitCount, unitTime, expiration)
+			local newTime   = curTime
+			local diffVal   = unitTime
+			local littleWin = 1
+			if unitTime > 1000 then
+				littleWin = math.ceil(unitTime / 1000)
+				newTime = math.floor(curTime / littleWin)
+				diffVal = math.floor(unitTime / littleWin)
+			end
+
+			local beforeCount = 0
+			local slots       = {}
+			local expired     = {}
+			local flatMap     = redis.call('HGETALL', key)
+			for i = 1, #flatMap, 2 do
+				local ftime = tonumber(flatMap[i])
+				if newTime - ftime < diffVal then
+					local fcount = tonumber(flatMap[i + 1])
+					beforeCount = beforeCount + fcount
+					table.insert(slots, {ftime, fcount})
+				else
+					table.insert(expired, flatMap[i])
+				end
+			end
+			table.sort(slots, function(a, b) return a[1] < b[1] end)
+
+			if beforeCount + cost > limitCount then
+				if cost > limitCount or #slots == 0 then
+					return {result = {0, limitCount, math.max(0, limitCount - beforeCount), -1, 0}}
+				end
+
+				local retryAfter = 0
+				local released   = 0
+				for _, slot in ipairs(slots) do
+					released = released + slot[2]
+					retryAfter = (slot[1] + diffVal) * littleWin - curTime
+					if beforeCount - released + cost <= limitCount then
+						break
+					end
+				end
+				local resetAfter = (slots[#slots][1] + diffVal) * littleWin - curTime
+				return {result = {0, limitCount, math.max(0, limitCount - beforeCount), retryAfter, resetAfter}}
+			end
+
+			local commit = function()
+				for _, field in ipairs(expired) do
+					redis.call('HDEL', key, field)
+				end
+				redis.call('HINCRBY', key, tostring(newTime), cost)
+				redis.call('PEXPIRE', key, expiration)
+			end
+			return {result = {1, limitCount, limitCount - beforeCount - cost, 0, (newTime + diffVal) * littleWin - curTime}, commit = commit}
+		end
+
+		-- 令牌桶: 按上次填充时间推算当前令牌数, 写入时保存填充时间及剩余令牌并刷新过期时间
+		-- 预约模式下令牌不足时允许透支, 透支部分需在重置间隔内偿还完毕, 剩余令牌为负数
+		local function checkTokenBucket(key, intervalPerPermit, bucketMaxTokens, resetBucketInterval, initTokens, expiration)
+			local bucket          = redis.call('HMGET', key, 'lastRefillTime', 'tokensRemaining')
+			local lastRefillTime  = tonumber(bucket[1])
+			local tokensRemaining = tonumber(bucket[2])
+			local currentTokens   = 0
+			local refilled        = false
+
+			if not lastRefillTime then
+				currentTokens = initTokens
+				lastRefillTime = curTime
+				refilled = true
+			elseif curTime <= lastRefillTime then
+				currentTokens = tokensRemaining
+			else
+				local intervalSinceLast = curTime - lastRefillTime
+				if intervalSinceLast > resetBucketInterval then
+					currentTokens = initTokens
+					lastRefillTime = curTime
+					refilled = true
+				else
+					-- 不足一个令牌的时间(可为小数)留到下次累计
+					local availableTokens = math.floor(intervalSinceLast / intervalPerPermit)
+					if availableTokens > 0 then
+						lastRefillTime = curTime - math.fmod(intervalSinceLast, intervalPerPermit)
+					end
+					currentTokens = math.min(availableTokens + tokensRemaining, bucketMaxTokens)
+				end
+			end
+
+			-- 距离下一个令牌生成的时间, 返回的时间均向上取整为毫秒
+			local nextPermit = math.max(0, lastRefillTime + intervalPerPermit - curTime)
+			local fullAfter = function()
+				if currentTokens >= bucketMaxTokens then
+					return 0
+				end
+				return math.ceil(nextPermit + (bucketMaxTokens - currentTokens - 1) * intervalPerPermit)
+			end
+			local commit = function()
+				redis.call('HMSET', key, 'lastRefillTime', lastRefillTime, 'tokensRemaining', currentTokens)
+				redis.call('PEXPIRE', key, expiration)
+			end
+
+			if currentTokens < cost then
+				-- 单次消耗超过令牌桶上限时永远无法满足
+				local retryAfter = -1
+				if cost <= bucketMaxTokens then
+					retryAfter = math.ceil(nextPermit + (cost - currentTokens - 1) * intervalPerPermit)
+				end
+
+				if reserve == 1 and retryAfter >= 0 and retryAfter <= resetBucketInterval then
+					currentTokens = currentTokens - cost
+					return {result = {1, bucketMaxTokens, 0, retryAfter, fullAfter()}, commit = commit}
+				end
+
+				-- 新建或重置的桶即使拒绝也要保存填充时间, 否则初始令牌数不足时每次都从初始令牌数重新开始, 永远无法放行
+				if refilled then
+					commit()
+				end
+				return {result = {0, bucketMaxTokens, math.max(0, currentTokens), retryAfter, fullAfter()}}
+			end
+
+			currentTokens = currentTokens - cost
+			return {result = {1, bucketMaxTokens, currentTokens, 0, fullAfter()}, commit = commit}
+		end
+
+		-- 漏桶: 按上次漏水时间推算桶中水量, 写入时保存水量及漏水时间并刷新过期时间
+		-- 预约模式下桶满时允许排队(最多再排一个桶的容量), 重试间隔即为排队等待的时间
+		local function checkLeakyBucket(key, capacity, leakRate, expiration)
+			if leakRate <= 0 then
+				return {result = {0, capacity, 0, -1, 0}}
+			end
+
+			local mresult      = redis.call('HMGET', key, 'currentWater', 'lastLeakTime')
+			local currentWater = tonumber(mresult[1]) or 0
+			local lastLeakTime = tonumber(mresult[2]) or curTime
+
+			-- 只漏出整数水量, 上次漏水时间只前进漏出整数水量所用的时间; 漏空时从当前时间重新计算
+			local leakedWater = math.floor(math.max(0, curTime - lastLeakTime) * leakRate / 1000)
+			local newWater    = math.max(0, currentWater - leakedWater)
+			if newWater == 0 then
+				lastLeakTime = curTime
+			else
+				lastLeakTime = lastLeakTime + leakedWater * 1000 / leakRate
+			end
+			local pending = curTime - lastLeakTime
+			local emptyAfter = function()
+				return math.ceil(newWater * 1000 / leakRate - pending)
+			end
+			local commit = function()
+				redis.call('HMSET', key, 'currentWater', newWater, 'lastLeakTime', lastLeakTime)
+				redis.call('PEXPIRE', key, expiration)
+			end
+
+			if newWater + cost > capacity then
+				-- 需等待漏出足够的水量, 单次水量超过桶容量时永远无法满足
+				local retryAfter = math.ceil((newWater + cost - capacity) * 1000 / leakRate - pending)
+				if cost > capacity then
+					retryAfter = -1
+				end
+
+				if reserve == 1 and retryAfter >= 0 and newWater + cost <= capacity * 2 then
+					newWater = newWater + cost
+					return {result = {1, capacity, 0, retryAfter, emptyAfter()}, commit = commit}
+				end
+				return {result = {0, capacity, math.max(0, capacity - newWater), retryAfter, emptyAfter()}}
+			end
+
+			newWater = newWater + cost
+			return {result = {1, capacity, capacity - newWater, 0, emptyAfter()}, commit = commit}
+		end
+
+		-- 按限流器类型校验, 未知类型返回 nil
+		local function checkLimiter(key, limiterType, a1, a2, a3, a4, a5)
+			if limiterType == 'FixedWindow' then
+				return checkFixedWindow(key, a1, a2, a3)
+			elseif limiterType == 'SlideWindow' then
+				return checkSlideWindow(key, a1, a2, a3)
+			elseif limiterType == 'TokenBucket' then
+				return checkTokenBucket(key, a1, a2, a3, a4, a5)
+			elseif limiterType == 'LeakyBucket' then
+				return checkLeakyBucket(key, a1, a2, a3)
+			elseif limiterType == 'CalendarQuota' then
+				return checkCalendarQuota(key, a1, a2)
+			end
+			return nil
+		end
+`
+
+var luaScriptShaMap, luaScriptOptShaMap map[string]string
+
 func init() {
 	luaScriptMap = make(map[string]string, 4)
+	// 单一限流器脚本只负责解析参数, 限流逻辑调用 luaLimiterChecks 中对应的校验函数, 与组合限流及惩罚脚本保持一致
 	// 固定窗口限流脚本
 	luaScriptMap["FixedWindowScript"] = `
 		--[[
@@ -21,107 +246,527 @@ func init() {
 
 			1. key        - [V] 限流 key
 			2. limit      - [V] 限流大小
-			3. unitTime   - [-] 窗口大小, 默认窗口1s
-			4. expiration - [-] Key的过期时间, 默认过期2s
+			3. unitTime   - [-] 窗口大小, 单位ms, 默认窗口1000ms
+			4. expiration - [-] Key的过期时间, 单位ms, 默认为两个窗口
+			5. curTime    - [-] 当前时间, 单位ms, 用于计算窗口重置时间
+			6. cost       - [-] 本次消耗的请求数, 默认1
+
+			返回: {是否放行, 限流大小, 剩余可用请求数, 重试间隔(ms), 距离窗口重置时间(ms)}
+		--]]
+
+		local key        = KEYS[1]
+		local limit      = tonumber(ARGV[1])
+		local unitTime   = tonumber(ARGV[2]) or 1000
+		local expiration = tonumber(ARGV[3]) or unitTime * 2
+		local curTime    = tonumber(ARGV[4]) or 0
+		local cost       = tonumber(ARGV[5]) or 1
+		local reserve    = 0
+
+	` + luaLimiterChecks + `
+		local state = checkFixedWindow(key, limit, unitTime, expiration)
+		if state.commit then
+			state.commit()
+		end
+		return state.result
+	`
+	// 滑动窗口限流脚本
+	luaScriptMap["SlideWindowScript"] = `
+		--[[
+			Description: 基于 Reids Hash 实现, 最小窗口限制为1s, 最大窗口限制为3600s
+						流量会在时间窗口基础上再拆分为更细粒度的窗口进行存储, 流量的放开会随着时间的滚动而逐步放开流量限制
+
+			1. key        - [V] 限流 key
+			2. limitCount - [V] 单个时间窗口限制数量
+			3. curTime    - [V] 当前时间, 单位ms
+			4. unitTime   - [V] 时间窗口范围, 单位ms
+			5. expiration - [V] 集合key过期时间, 单位ms, 当key过期时会存在瞬时并发的情况, 因此过期时间不能太短或者改用定时清除
+			6. cost       - [-] 本次消耗的请求数, 默认1
+
+			返回: {是否放行, 限流大小, 剩余可用请求数, 重试间隔(ms), 距离窗口清空时间(ms)}
+		--]]
+
+		local key        = KEYS[1]
+		local limitCount = tonumber(ARGV[1])
+		local curTime    = tonumber(ARGV[2])
+		local unitTime   = tonumber(ARGV[3])
+		local expiration = tonumber(ARGV[4])
+		local cost       = tonumber(ARGV[5]) or 1
+		local reserve    = 0
+
+	` + luaLimiterChecks + `
+		local state = checkSlideWindow(key, limitCount, unitTime, expiration)
+		if state.commit then
+			state.commit()
+		end
+		return state.result
+	`
+	// 令牌桶限流脚本
+	luaScriptMap["TokenBucketScript"] = `
+		--[[
+			Description: 基于 Reids Hash 实现
+
+			1. key                 - [V] 令牌桶的 key
+			2. intervalPerPermit   - [V] 生成令牌的间隔(ms), 可为小数
+			3. curTime             - [V] 当前时间(ms)
+			4. bucketMaxTokens     - [V] 令牌桶的上限
+			5. resetBucketInterval - [V] 重置桶内令牌的时间间隔(ms), 可为小数
+			6. initTokens          - [-] 令牌桶初始化的令牌数
+			7. cost                - [-] 本次消耗的令牌数, 默认1
+			8. reserve             - [-] 是否为预约模式, 默认0; 预约模式下令牌不足时允许透支, 返回需等待的时间
+			9. expiration          - [-] 令牌桶的过期时间(ms), 默认为重置间隔的 10 倍, 每次写入时刷新
+			
+			currentTokens          - 当前桶内令牌数, 透支时为负数
+			bucket                 - 当前 key 的令牌桶对象
+
+			返回: {是否放行, 令牌桶上限, 剩余令牌数, 重试间隔(ms), 距离桶满时间(ms)}
+			预约模式放行时, 重试间隔即为可执行前需等待的时间
+		--]]
+
+		local key                 = KEYS[1]
+		local intervalPerPermit   = tonumber(ARGV[1])
+		local curTime             = tonumber(ARGV[2])
+		local bucketMaxTokens     = tonumber(ARGV[3])
+		local resetBucketInterval = tonumber(ARGV[4])
+		local initTokens          = tonumber(ARGV[5]) or 0
+		local cost                = tonumber(ARGV[6]) or 1
+		local reserve             = tonumber(ARGV[7]) or 0
+		local expiration          = tonumber(ARGV[8]) or resetBucketInterval * 10
+
+	` + luaLimiterChecks + `
+		local state = checkTokenBucket(key, intervalPerPermit, bucketMaxTokens, resetBucketInterval, initTokens, expiration)
+		if state.commit then
+			state.commit()
+		end
+		return state.result
+	`
+	// 漏桶限流脚本
+	luaScriptMap["LeakyBucketScript"] = `
+		--[[
+			Description: 主要逻辑是判断当前请求是否可以被放入桶中，如果可以放入则可以执行本次请求，否则拒绝本次请求 - 基于 Redis Hash 实现
+			
+			漏桶算法的实现主要分为三个步骤：
+				1. 未满加水：通过代码 water += 1 进行不停加水的动作。
+				2. 漏桶漏水：通过时间差来计算漏水量
+				3. 剩余水量：总水量-漏水量
+
+			注解：
+				1. 当请求速率小于漏水速率时，几乎所有请求都会通过
+				2. 当请求速率大于漏水速率时，超出桶容量的请求会被拒绝
+				3. 即使在突发流量下，也能保证处理速率不超过漏水速率
+				4. 桶容量决定了系统能处理的突发流量大小
+
+			场景：
+				1. API 调用限制
+				2. 消息处理队列
+				3. 数据库写入限制
+
+			1. key        - [V] 漏桶 Key
+			2. capacity   - [V] 桶的容量
+			3. leakRate   - [V] 漏水速率, 单位是每秒漏多少个请求, 可为小数
+			4. curTime    - [V] 当前时间, 单位ms
+			5. cost       - [-] 本次加入的水量, 默认1
+			6. reserve    - [-] 是否为预约模式, 默认0; 预约模式下桶满时允许排队(最多再排一个桶的容量), 返回需等待的时间
+			7. expiration - [-] Key 过期时间, 单位ms, 默认为漏空两倍容量所需的时间
+
+			返回: {是否放行, 桶的容量, 剩余容量, 重试间隔(ms), 距离桶空时间(ms)}
+			预约模式放行时, 重试间隔即为可执行前需等待的时间
+		--]]
+
+		local key      = KEYS[1]
+		local capacity = tonumber(ARGV[1])
+		local leakRate = tonumber(ARGV[2])
+		local curTime  = tonumber(ARGV[3])
+		local cost     = tonumber(ARGV[4]) or 1
+		local reserve  = tonumber(ARGV[5]) or 0
+
+		-- 参数校验
+		if not capacity or not leakRate or not curTime then
+			return {0, capacity or 0, 0, -1, 0}
+		end
+
+		-- 桶漏空之后状态不再有意义, 默认过期时间为漏空两倍容量所需的时间
+		local expiration = tonumber(ARGV[6])
+		if not expiration and leakRate > 0 then
+			expiration = math.ceil(capacity * 1000 / leakRate) * 2
+		end
+
+	` + luaLimiterChecks + `
+		local state = checkLeakyBucket(key, capacity, leakRate, expiration)
+		if state.commit then
+			state.commit()
+		end
+		return state.result
+	`
+	// 日历配额限流脚本
+	luaScriptMap["CalendarQuotaScript"] = `
+		--[[
+			Description: 按自然周期计数, 周期边界由调用方按时区计算(处理夏令时), 脚本只负责计数; Key 随周期滚动, 在周期结束时过期
+
+			1. key        - [V] 限流 key, 后缀为周期开始时间
+			2. limit      - [V] 每个周期的限流大小
+			3. resetAfter - [V] 距离周期结束的时间, 单位ms, 同时作为 Key 的过期时间
+			4. cost       - [-] 本次消耗的请求数, 默认1
+
+			返回: {是否放行, 限流大小, 剩余可用请求数, 重试间隔(ms), 距离周期结束时间(ms)}
+		--]]
+
+		local key        = KEYS[1]
+		local limit      = tonumber(ARGV[1])
+		local resetAfter = tonumber(ARGV[2])
+		local cost       = tonumber(ARGV[3]) or 1
+		local curTime    = 0
+		local reserve    = 0
+
+	` + luaLimiterChecks + `
+		local state = checkCalendarQuota(key, limit, resetAfter)
+		if state.commit then
+			state.commit()
+		end
+		return state.result
+	`
+	// 固定窗口归还请求数脚本
+	luaScriptMap["FixedWindowRefundScript"] = `
+		--[[
+			Description: 扣减当前窗口的计数器, 扣减后计数不小于0
+
+			1. key  - [V] 限流 key
+			2. cost - [V] 归还的请求数
+
+			返回: 实际归还的请求数
 		--]]
 
-		local key       = KEYS[1]
-		local limit     = tonumber(ARGV[1])
-		local unitTime = 1
-		if ARGV[2] ~= nil then
-			unitTime = tonumber(ARGV[2])
+		local key  = KEYS[1]
+		local cost = tonumber(ARGV[1])
+
+		local current = tonumber(redis.call('GET', key))
+		-- 窗口已过期或未计数时无需归还
+		if not current or current <= 0 then
+			return 0
 		end
 
-		-- 设定过期周期(300~3600s)
-		local expiration  = math.ceil(unitTime * 2)
-		if ARGV[3] ~= nil then
-			expiration = tonumber(ARGV[3])
+		local refund = math.min(cost, current)
+		redis.call('DECRBY', key, refund)
+		return refund
+	`
+	// 滑动窗口归还请求数脚本
+	luaScriptMap["SlideWindowRefundScript"] = `
+		--[[
+			Description: 从最新的小格子开始扣减计数, 计数归零的小格子直接删除
+
+			1. key  - [V] 限流 key
+			2. cost - [V] 归还的请求数
+
+			返回: 实际归还的请求数
+		--]]
+
+		local key  = KEYS[1]
+		local cost = tonumber(ARGV[1])
+
+		local slots   = {}
+		local flatMap = redis.call('HGETALL', key)
+		for i = 1, #flatMap, 2 do
+			table.insert(slots, {flatMap[i], tonumber(flatMap[i + 1])})
 		end
+		table.sort(slots, function(a, b) return tonumber(a[1]) > tonumber(b[1]) end)
+
+		local refund = 0
+		for _, slot in ipairs(slots) do
+			if refund >= cost then
+				break
+			end
 
-		local current = tonumber(redis.call('GET', key) or "0")
+			if slot[2] > 0 then
+				local count = math.min(cost - refund, slot[2])
+				if count >= slot[2] then
+					redis.call('HDEL', key, slot[1])
+				else
+					redis.call('HINCRBY', key, slot[1], -count)
+				end
+				refund = refund + count
+			end
+		end
 
-		-- 超出限流大小
-		if current and current >= limit then 
+		return refund
+	`
+	// 令牌桶归还令牌脚本
+	luaScriptMap["TokenBucketRefundScript"] = `
+		--[[
+			Description: 将已消耗(或透支)的令牌归还到令牌桶, 归还后不超过令牌桶上限
+
+			1. key             - [V] 令牌桶的 key
+			2. cost            - [V] 归还的令牌数
+			3. bucketMaxTokens - [V] 令牌桶的上限
+
+			返回: 实际归还的令牌数
+		--]]
+
+		local key             = KEYS[1]
+		local cost            = tonumber(ARGV[1])
+		local bucketMaxTokens = tonumber(ARGV[2])
+
+		local tokensRemaining = tonumber(redis.call('HGET', key, 'tokensRemaining'))
+		-- 令牌桶不存在(已过期)时无需归还
+		if not tokensRemaining then
 			return 0
 		end
 
-		current = redis.call('INCR', key)
-		-- 第一次请求, 则设置过期时间
-		if current == 1 then
-			redis.call('EXPIRE', key, expiration)
+		local newTokens = math.min(tokensRemaining + cost, bucketMaxTokens)
+		if newTokens <= tokensRemaining then
+			return 0
 		end
 
-		-- 返回剩余可用请求数
-		return limit - current + 1
+		redis.call('HSET', key, 'tokensRemaining', newTokens)
+		return newTokens - tokensRemaining
 	`
-	// 滑动窗口限流脚本
-	luaScriptMap["SlideWindowScript"] = `
+	// 漏桶归还水量脚本
+	luaScriptMap["LeakyBucketRefundScript"] = `
 		--[[
-			Description: 基于 Reids Hash 实现, 最小窗口限制为1s, 最大窗口限制为3600s
-						流量会在时间窗口基础上再拆分为更细粒度的窗口进行存储, 流量的放开会随着时间的滚动而逐步放开流量限制
+			Description: 将已加入漏桶的水量取出, 取出后水量不小于0
+
+			1. key  - [V] 漏桶 Key
+			2. cost - [V] 取出的水量
+
+			返回: 实际取出的水量
+		--]]
+
+		local key  = KEYS[1]
+		local cost = tonumber(ARGV[1])
+
+		local currentWater = tonumber(redis.call('HGET', key, 'currentWater'))
+		-- 漏桶不存在或已漏空时无需归还
+		if not currentWater or currentWater <= 0 then
+			return 0
+		end
+
+		local newWater = math.max(0, currentWater - cost)
+		redis.call('HSET', key, 'currentWater', newWater)
+		return currentWater - newWater
+	`
+
+	// 组合限流脚本
+	luaScriptMap["CompositeScript"] = `
+		--[[
+			Description: 在一次脚本调用中校验多条限流规则, 全部规则放行时才统一写入, 任一规则拒绝时不消耗任何规则的许可
+
+			KEYS[i]            - [V] 第 i 条规则的限流 key, 各规则的 key 不能重复
+			1. cost            - [V] 本次消耗的许可数
+			2. curTime         - [V] 当前时间(ms)
+			3. 每条规则7个参数 - [V] 限流器类型及对应参数, 不足6个参数时补0
+				FixedWindow: limit, unitTime(ms), expiration(ms)
+				SlideWindow: limitCount, unitTime(ms), expiration(ms)
+				TokenBucket: intervalPerPermit(ms), bucketMaxTokens, resetBucketInterval(ms), initTokens, expiration(ms)
+				LeakyBucket: capacity, leakRate(每秒), expiration(ms)
+				CalendarQuota: limit, resetAfter(ms), 即距离周期结束的时间, 同时作为 Key 的过期时间
+
+			返回: {是否放行, 第一个拒绝的规则序号(从1开始, 放行时为0), 各规则的{是否放行, 限流大小, 剩余可用请求数, 重试间隔(ms), 距离状态恢复时间(ms)}...}
+			拒绝时各规则的剩余可用请求数不含本次请求
+		--]]
+
+		local cost    = tonumber(ARGV[1])
+		local curTime = tonumber(ARGV[2])
+		local reserve = 0
+		local stride  = 7
+
+	` + luaLimiterChecks + `
+		-- 第一阶段: 依次校验全部规则, 不消耗任何许可(令牌桶新建或重置时仅保存填充时间)
+		local states   = {}
+		local rejected = 0
+		for i = 1, #KEYS do
+			local base = 2 + (i - 1) * stride
+			local limiterType = ARGV[base + 1]
+			local a1, a2, a3 = tonumber(ARGV[base + 2]), tonumber(ARGV[base + 3]), tonumber(ARGV[base + 4])
+			local a4, a5 = tonumber(ARGV[base + 5]), tonumber(ARGV[base + 6])
+
+			local state = checkLimiter(KEYS[i], limiterType, a1, a2, a3, a4, a5)
+			if not state then
+				return redis.error_reply('unknown limiter type ' .. tostring(limiterType))
+			end
+
+			states[i] = state
+			if state.result[1] == 0 and rejected == 0 then
+				rejected = i
+			end
+		end
+
+		-- 第二阶段: 全部规则放行时统一写入
+		local allowed = 0
+		if rejected == 0 then
+			allowed = 1
+			for _, state in ipairs(states) do
+				state.commit()
+			end
+		end
+
+		local ret = {allowed, rejected}
+		for _, state in ipairs(states) do
+			for _, v in ipairs(state.result) do
+				table.insert(ret, v)
+			end
+		end
+		return ret
+	`
+
+	// 惩罚限流脚本
+	luaScriptMap["PenaltyScript"] = `
+		--[[
+			Description: 先检查主体是否处于封禁期, 未封禁时执行限流; 周期内被拒绝次数达到阈值时封禁, 封禁时长随违规次数指数增长
+
+			KEYS[1]              - [V] 限流 key
+			KEYS[2]              - [V] 惩罚 key, Hash 结构: rejections 周期内被拒绝次数, periodEnd 统计周期结束时间, offences 违规次数, bannedUntil 封禁结束时间
+			1. cost              - [V] 本次消耗的许可数
+			2. curTime           - [V] 当前时间(ms)
+			3. 限流器类型及6个参数 - [V] 与组合限流脚本的单条规则一致
+			10. threshold        - [V] 周期内被拒绝次数阈值
+			11. period           - [V] 统计被拒绝次数的周期(ms)
+			12. banTime          - [V] 首次封禁时长(ms), 之后每次翻倍
+			13. maxBanTime       - [V] 最长封禁时长(ms), 封禁结束后该时长内再次违规时封禁时长继续翻倍
+
+			返回: {是否放行, 限流大小, 剩余可用请求数, 重试间隔(ms), 距离状态恢复时间(ms), 是否封禁}
+			处于封禁期时不执行限流, 限流大小为0, 重试间隔为剩余封禁时长
+		--]]
+
+		local cost       = tonumber(ARGV[1])
+		local curTime    = tonumber(ARGV[2])
+		local reserve    = 0
+		local threshold  = tonumber(ARGV[10])
+		local period     = tonumber(ARGV[11])
+		local banTime    = tonumber(ARGV[12])
+		local maxBanTime = tonumber(ARGV[13])
+		local penaltyKey = KEYS[2]
+
+		local penalty     = redis.call('HMGET', penaltyKey, 'rejections', 'periodEnd', 'offences', 'bannedUntil')
+		local rejections  = tonumber(penalty[1]) or 0
+		local periodEnd   = tonumber(penalty[2]) or 0
+		local offences    = tonumber(penalty[3]) or 0
+		local bannedUntil = tonumber(penalty[4]) or 0
+
+		-- 封禁期内直接拒绝
+		if bannedUntil > curTime then
+			return {0, 0, 0, bannedUntil - curTime, bannedUntil - curTime, 1}
+		end
+	` + luaLimiterChecks + `
+		local state = checkLimiter(KEYS[1], ARGV[3], tonumber(ARGV[4]), tonumber(ARGV[5]), tonumber(ARGV[6]), tonumber(ARGV[7]), tonumber(ARGV[8]))
+		if not state then
+			return redis.error_reply('unknown limiter type ' .. tostring(ARGV[3]))
+		end
+
+		local ret = state.result
+		if ret[1] == 1 then
+			state.commit()
+			table.insert(ret, 0)
+			return ret
+		end
+
+		-- 统计周期已结束时重新计数
+		if periodEnd <= curTime then
+			rejections = 0
+			periodEnd = curTime + period
+		end
+		rejections = rejections + 1
+
+		if rejections < threshold then
+			redis.call('HMSET', penaltyKey, 'rejections', rejections, 'periodEnd', periodEnd)
+			if redis.call('PTTL', penaltyKey) < period then
+				redis.call('PEXPIRE', penaltyKey, period)
+			end
+			table.insert(ret, 0)
+			return ret
+		end
+
+		-- 达到阈值, 封禁时长按违规次数翻倍
+		local ban = math.min(banTime * math.pow(2, math.min(offences, 62)), maxBanTime)
+		offences = offences + 1
+		bannedUntil = curTime + ban
+		redis.call('HMSET', penaltyKey, 'rejections', 0, 'periodEnd', 0, 'offences', offences, 'bannedUntil', bannedUntil)
+		redis.call('PEXPIRE', penaltyKey, ban + maxBanTime)
+
+		return {0, ret[2], ret[3], ban, math.max(ret[5], ban), 1}
+	`
+
+	// 固定窗口只读查询脚本
+	luaScriptMap["FixedWindowInspectScript"] = `
+		--[[
+			Description: 只读查询固定窗口当前状态, 不执行任何写操作
+
+			1. key      - [V] 限流 key
+			2. limit    - [V] 限流大小
+			3. unitTime - [V] 窗口大小, 单位ms
+			4. curTime  - [V] 当前时间, 单位ms
+
+			返回: {限流大小, 窗口已用请求数, 剩余可用请求数, 窗口开始时间(ms), 距离窗口重置时间(ms)}
+		--]]
+
+		local key      = KEYS[1]
+		local limit    = tonumber(ARGV[1])
+		local unitTime = tonumber(ARGV[2])
+		local curTime  = tonumber(ARGV[3])
+
+		local windowStart = curTime - math.fmod(curTime, unitTime)
+		local current     = tonumber(redis.call('GET', key) or "0")
+
+		return {limit, current, math.max(0, limit - current), windowStart, windowStart + unitTime - curTime}
+	`
+	// 滑动窗口只读查询脚本
+	luaScriptMap["SlideWindowInspectScript"] = `
+		--[[
+			Description: 只读查询滑动窗口当前状态, 不执行任何写操作, 过期的小格子仅跳过不删除
 
 			1. key        - [V] 限流 key
 			2. limitCount - [V] 单个时间窗口限制数量
 			3. curTime    - [V] 当前时间, 单位ms
-			4. unitTime   - [V] 时间窗口范围, 传参单位秒, 默认窗口1秒
-			5. expiration - [V] 集合key过期时间, 当key过期时会存在瞬时并发的情况, 因此过期时间不能太短或者改用定时清除
+			4. unitTime   - [V] 时间窗口范围, 单位ms
+
+			返回: {限流大小, 窗口内已用请求数, 剩余可用请求数, 0, 距离窗口清空时间(ms)}
 		--]]
 
 		local key         = KEYS[1]
 		local limitCount  = tonumber(ARGV[1])
 		local curTime     = tonumber(ARGV[2])
-		local unitTime    = tonumber(ARGV[3]) * 1000
-		local expiration  = tonumber(ARGV[4])
+		local unitTime    = tonumber(ARGV[3])
 		local newTime     = curTime
 		local diffVal     = unitTime
+		local littleWin   = 1
 		local constKeyCnt = 1000
 
 		if unitTime > 1000 then
-			local littleWin = math.ceil(unitTime / constKeyCnt)
+			littleWin = math.ceil(unitTime / constKeyCnt)
 			newTime = math.floor(curTime / littleWin)
 			diffVal = math.floor(unitTime / littleWin)
 		end
 
-		-- 已访问的次数
-		local beforeCount  = 0
-		local flatMap      = redis.call('HGETALL', key)
-		if table.maxn(flatMap) > 0 then
-			for i = 1, #flatMap, 2 do
-				local ftime = tonumber(flatMap[i])
-				if newTime - ftime < diffVal then
-					beforeCount = beforeCount + tonumber(flatMap[i + 1])
-				else
-					redis.call('HDEL', key, tostring(ftime))
+		local beforeCount = 0
+		local newestTime  = nil
+		local flatMap     = redis.call('HGETALL', key)
+		for i = 1, #flatMap, 2 do
+			local ftime = tonumber(flatMap[i])
+			if newTime - ftime < diffVal then
+				beforeCount = beforeCount + tonumber(flatMap[i + 1])
+				if newestTime == nil or ftime > newestTime then
+					newestTime = ftime
 				end
 			end
 		end
 
-		local result = 0
-		if limitCount <= beforeCount then
-			return result
+		local resetAfter = 0
+		if newestTime ~= nil then
+			resetAfter = (newestTime + diffVal) * littleWin - curTime
 		end
 
-		result = limitCount - beforeCount
-		redis.call('HINCRBY', key, tostring(newTime), '1')
-		redis.call('EXPIRE', key, expiration)
-
-		-- 返回剩余可用请求量，含本次请求
-		return result
+		return {limitCount, beforeCount, math.max(0, limitCount - beforeCount), 0, resetAfter}
 	`
-	// 令牌桶限流脚本
-	luaScriptMap["TokenBucketScript"] = `
+	// 令牌桶只读查询脚本
+	luaScriptMap["TokenBucketInspectScript"] = `
 		--[[
-			Description: 基于 Reids Hash 实现
+			Description: 只读查询令牌桶当前状态, 按当前时间推算可用令牌数, 不执行任何写操作
 
 			1. key                 - [V] 令牌桶的 key
-			2. intervalPerPermit   - [V] 生成令牌的间隔(ms)
+			2. intervalPerPermit   - [V] 生成令牌的间隔(ms), 可为小数
 			3. curTime             - [V] 当前时间(ms)
 			4. bucketMaxTokens     - [V] 令牌桶的上限
-			5. resetBucketInterval - [V] 重置桶内令牌的时间间隔(ms)
-			6. initTokens          - [-] 令牌桶初始化的令牌数
-			
-			7. currentTokens       - 当前桶内令牌数
-			8. bucket              - 当前 key 的令牌桶对象
+			5. resetBucketInterval - [V] 重置桶内令牌的时间间隔(ms), 可为小数
+			6. initTokens          - [V] 令牌桶初始化的令牌数
+
+			返回: {令牌桶上限, 已消耗令牌数, 剩余令牌数, 最近填充时间(ms), 距离桶满时间(ms)}
 		--]]
 
 		local key                 = KEYS[1]
@@ -129,149 +774,75 @@ func init() {
 		local curTime             = tonumber(ARGV[2])
 		local bucketMaxTokens     = tonumber(ARGV[3])
 		local resetBucketInterval = tonumber(ARGV[4])
+		local initTokens          = tonumber(ARGV[5])
 
-		local initTokens          = 0
-		if ARGV[5] ~= nil then
-			initTokens = tonumber(ARGV[5])
-		end
-
-
-		local currentTokens       = 0
-		local bucket = redis.call('HGETALL', key)
+		local bucket          = redis.call('HMGET', key, 'lastRefillTime', 'tokensRemaining')
+		local lastRefillTime  = tonumber(bucket[1])
+		local tokensRemaining = tonumber(bucket[2])
 
-		-- 若当前桶未初始化,先初始化令牌桶
-		if table.maxn(bucket) == 0 then
-			-- 初始桶内令牌
-			currentTokens = initTokens
-			-- 设置桶最近的填充时间是当前
-			redis.call('HSET', key, 'lastRefillTime', curTime)
-			-- 如果当前令牌 == 0 ,更新桶内令牌, 返回 0
-			redis.call('HSET', key, 'tokensRemaining', currentTokens)
-			-- 初始化令牌桶的过期时间, 设置为间隔的 10 倍
-			redis.call('PEXPIRE', key, resetBucketInterval * 10)
-			-- 返回令牌数
-			return math.max(1, currentTokens)
+		-- 令牌桶未初始化
+		if not lastRefillTime then
+			return {bucketMaxTokens, bucketMaxTokens - initTokens, initTokens, 0, math.ceil((bucketMaxTokens - initTokens) * intervalPerPermit)}
 		end
 
-		-- 上次填充时间
-		local lastRefillTime = tonumber(bucket[2])
-		-- 剩余的令牌数
-		local tokensRemaining = tonumber(bucket[4])
-
-		-- 如果当前时间小于或等于上次更新的时间, 当前令牌数量等于桶内令牌数(幂等性)
-		if curTime <= lastRefillTime then
-			currentTokens = tokensRemaining
-		-- 当前时间大于上次填充时间
-		else
-			-- 拿到当前时间与上次填充时间的时间间隔
+		local currentTokens = tokensRemaining
+		if curTime > lastRefillTime then
 			local intervalSinceLast = curTime - lastRefillTime
-
-			-- 如果当前时间间隔 大于 令牌的生成间隔
 			if intervalSinceLast > resetBucketInterval then
-				-- 将当前令牌填充满
 				currentTokens = initTokens
-
-				-- 更新重新填充时间
-				redis.call('HSET', key, 'lastRefillTime', curTime)
-
-			-- 如果当前时间间隔 小于 令牌的生成间隔
+				lastRefillTime = curTime
 			else
-				-- 可用的令牌数 = 向下取整数( 上次填充时间与当前时间的时间间隔 / 两个令牌许可之间的时间间隔 )
 				local availableTokens = math.floor(intervalSinceLast / intervalPerPermit)
-
-				-- 可授予的令牌 > 0 时
 				if availableTokens > 0 then
-					-- 生成的令牌 = 上次填充时间与当前时间的时间间隔 % 两个令牌许可之间的时间间隔
-					local padMillis = math.fmod(intervalSinceLast, intervalPerPermit)
-
-					-- 将当前令牌桶更新到上一次生成时间
-					redis.call('HSET', key, 'lastRefillTime', curTime - padMillis)
+					lastRefillTime = curTime - math.fmod(intervalSinceLast, intervalPerPermit)
 				end
-
-				-- 更新当前令牌桶中的令牌数
 				currentTokens = math.min(availableTokens + tokensRemaining, bucketMaxTokens)
 			end
 		end
 
-		local tokensCount = currentTokens
-		if (currentTokens > 0) then
-			currentTokens = currentTokens - 1
-			redis.call('HSET', key, 'tokensRemaining', currentTokens) 
+		local resetAfter = 0
+		if currentTokens < bucketMaxTokens then
+			resetAfter = math.ceil(math.max(0, lastRefillTime + intervalPerPermit - curTime) + (bucketMaxTokens - currentTokens - 1) * intervalPerPermit)
 		end
 
-		return tokensCount
+		return {bucketMaxTokens, bucketMaxTokens - currentTokens, math.max(0, currentTokens), math.floor(lastRefillTime), resetAfter}
 	`
-	// 漏桶限流脚本
-	luaScriptMap["LeakyBucketScript"] = `
+	// 漏桶只读查询脚本
+	luaScriptMap["LeakyBucketInspectScript"] = `
 		--[[
-			Description: 主要逻辑是判断当前请求是否可以被放入桶中，如果可以放入则可以执行本次请求，否则拒绝本次请求 - 基于 Redis Hash 实现
-			
-			漏桶算法的实现主要分为三个步骤：
-				1. 未满加水：通过代码 water += 1 进行不停加水的动作。
-				2. 漏桶漏水：通过时间差来计算漏水量
-				3. 剩余水量：总水量-漏水量
+			Description: 只读查询漏桶当前状态, 按当前时间推算桶中水量, 不执行任何写操作
 
-			注解：
-				1. 当请求速率小于漏水速率时，几乎所有请求都会通过
-				2. 当请求速率大于漏水速率时，超出桶容量的请求会被拒绝
-				3. 即使在突发流量下，也能保证处理速率不超过漏水速率
-				4. 桶容量决定了系统能处理的突发流量大小
-
-			场景：
-				1. API 调用限制
-				2. 消息处理队列
-				3. 数据库写入限制
+			1. key      - [V] 漏桶 Key
+			2. capacity - [V] 桶的容量
+			3. leakRate - [V] 漏水速率, 单位是每秒漏多少个请求, 可为小数
+			4. curTime  - [V] 当前时间, 单位ms
 
-			1. key        - [V] 漏桶 Key
-			2. capacity   - [V] 桶的容量
-			4. leakRate   - [V] 漏水速率, 单位是每秒漏多少个请求
-			4. curTime    - [V] 当前时间, 单位s
+			返回: {桶的容量, 桶中水量, 剩余容量, 上次漏水时间(ms), 距离桶空时间(ms)}
 		--]]
 
-		local key       = KEYS[1]
-		local capacity  = tonumber(ARGV[1])
-		local leakRate  = tonumber(ARGV[2])
-		local curTime   = tonumber(ARGV[3])
-
-		-- 参数校验
-		if not capacity or not leakRate or not curTime then
-			return 0
-		end
-
-		-- 获取桶中当前水量和上次漏水时间
-		local mresult = redis.call('HMGET', key, 'currentWater', 'lastLeakTime')
-		local currentWater = mresult[1]
-		local lastLeakTime = mresult[2]
+		local key      = KEYS[1]
+		local capacity = tonumber(ARGV[1])
+		local leakRate = tonumber(ARGV[2])
+		local curTime  = tonumber(ARGV[3])
 
-		-- 获取桶中水量
-		currentWater = tonumber(currentWater) or 0
+		local mresult      = redis.call('HMGET', key, 'currentWater', 'lastLeakTime')
+		local currentWater = tonumber(mresult[1]) or 0
+		local lastLeakTime = tonumber(mresult[2])
 
-		-- 获取上次漏水时间
-		lastLeakTime = tonumber(lastLeakTime) or curTime
-
-		-- 计算距离上次漏水经过的时间
-		local elapsedTime = curTime - tonumber(lastLeakTime)
-
-		-- 漏水操作，更新桶中水量 (时间间隔 * 漏水速率 = 漏水水量)
-		local leakedWater = math.floor(elapsedTime * leakRate)
-
-		-- 计算桶中剩余水量, 并保证不小于0 (桶中水量 - 漏水水量 = 桶中剩余水量)
-		local newWater = math.max(0, tonumber(currentWater) - leakedWater)
-
-		-- 更新桶中水量和上次漏水时间
-		redis.call('HMSET', key, 'currentWater', newWater, 'lastLeakTime', curTime)
-
-		-- 定义返回结果 0 表示不允许, 1 表示允许
-		local result = 0
+		if not lastLeakTime or leakRate <= 0 then
+			return {capacity, currentWater, math.max(0, capacity - currentWater), 0, 0}
+		end
 
-		-- 判断是否允许请求通过
-		if newWater < capacity then
-			-- 这里是将当前返回的水量加1, 代表桶中水量增加了一个请求的量
-			local re = redis.call('HINCRBY', key, 'currentWater', 1)
-			result = 1
+		local leakedWater = math.floor(math.max(0, curTime - lastLeakTime) * leakRate / 1000)
+		local newWater    = math.max(0, currentWater - leakedWater)
+		if newWater == 0 then
+			return {capacity, 0, capacity, math.floor(lastLeakTime), 0}
 		end
 
-		return result
+		lastLeakTime = lastLeakTime + leakedWater * 1000 / leakRate
+		local resetAfter = math.ceil(newWater * 1000 / leakRate - (curTime - lastLeakTime))
+
+		return {capacity, newWater, math.max(0, capacity - newWater), math.floor(lastLeakTime), resetAfter}
 	`
 
 	// 将脚本注释去除，并折叠为一行
@@ -279,6 +850,22 @@ func init() {
 	for k, v := range luaScriptMap {
 		luaScriptOptMap[k] = compressCode(v)
 	}
+
+	// 预先计算脚本的Sha值, 与 SCRIPT LOAD 返回值一致
+	luaScriptShaMap = make(map[string]string, len(luaScriptMap))
+	luaScriptOptShaMap = make(map[string]string, len(luaScriptOptMap))
+	for k, v := range luaScriptMap {
+		luaScriptShaMap[k] = scriptSha(v)
+	}
+	for k, v := range luaScriptOptMap {
+		luaScriptOptShaMap[k] = scriptSha(v)
+	}
+}
+
+// scriptSha 计算脚本的Sha1值
+func scriptSha(code string) string {
+	sum := sha1.Sum([]byte(code))
+	return hex.EncodeToString(sum[:])
 }
 
 func removeComments(code string) string {
@@ -349,7 +936,18 @@ func getLuaScript(limitType LimiterType, flag bool) string {
 		result = luaScript["TokenBucketScript"]
 	case LeakyBucketType:
 		result = luaScript["LeakyBucketScript"]
+	case CalendarQuotaType:
+		result = luaScript["CalendarQuotaScript"]
 	}
 
 	return result
 }
+
+// getLuaScriptByName 根据脚本名称获取 Lua 脚本及其Sha值
+func getLuaScriptByName(name string, flag bool) (script, sha string) {
+	if flag {
+		return luaScriptOptMap[name], luaScriptOptShaMap[name]
+	}
+
+	return luaScriptMap[name], luaScriptShaMap[name]
+}
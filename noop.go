@@ -0,0 +1,94 @@
+// Copyright(C) 2024 Github Inc. All Rights Reserved.
+// Author: metrue8@gmail.com
+// Date:   2024/01/03
+
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+)
+
+var (
+	_ Limiter = NoopLimiter{}
+	_ Limiter = DenyAllLimiter{}
+)
+
+// NoopLimiter 总是放行的限流器, 不访问 Redis, 用于单元测试或临时关闭限流
+type NoopLimiter struct{}
+
+// Allow 总是放行
+func (l NoopLimiter) Allow(ctx context.Context) (Decision, error) {
+	return l.AllowN(ctx, 1)
+}
+
+// AllowN 总是放行, n 非法时返回 ErrInvalidOptions
+func (NoopLimiter) AllowN(_ context.Context, n int64) (Decision, error) {
+	if n <= 0 {
+		return Decision{}, invalidPermitsErr(n)
+	}
+
+	return Decision{Allowed: true}, nil
+}
+
+// Wait 立即返回
+func (l NoopLimiter) Wait(ctx context.Context) error {
+	return l.WaitN(ctx, 1)
+}
+
+// WaitN 立即返回, n 非法时返回 ErrInvalidOptions
+func (l NoopLimiter) WaitN(ctx context.Context, n int64) error {
+	_, err := l.AllowN(ctx, n)
+	return err
+}
+
+// Inspect 返回空状态
+func (NoopLimiter) Inspect(context.Context) (LimiterState, error) {
+	return LimiterState{}, nil
+}
+
+// Reset 无状态可清除
+func (NoopLimiter) Reset(context.Context) error {
+	return nil
+}
+
+// DenyAllLimiter 总是拒绝的限流器, 不访问 Redis, 用于单元测试限流分支或紧急熔断
+type DenyAllLimiter struct{}
+
+// Allow 总是拒绝
+func (l DenyAllLimiter) Allow(ctx context.Context) (Decision, error) {
+	return l.AllowN(ctx, 1)
+}
+
+// AllowN 总是拒绝, 重试间隔为 -1 表示永远无法满足; n 非法时返回 ErrInvalidOptions
+func (DenyAllLimiter) AllowN(_ context.Context, n int64) (Decision, error) {
+	if n <= 0 {
+		return Decision{}, invalidPermitsErr(n)
+	}
+
+	return Decision{Allowed: false, RetryAfter: -1}, nil
+}
+
+// Wait 立即返回 ErrLimited
+func (l DenyAllLimiter) Wait(ctx context.Context) error {
+	return l.WaitN(ctx, 1)
+}
+
+// WaitN 立即返回 ErrLimited, 不会阻塞
+func (l DenyAllLimiter) WaitN(ctx context.Context, n int64) error {
+	if _, err := l.AllowN(ctx, n); err != nil {
+		return err
+	}
+
+	return fmt.Errorf("%w: deny all limiter", ErrLimited)
+}
+
+// Inspect 返回空状态
+func (DenyAllLimiter) Inspect(context.Context) (LimiterState, error) {
+	return LimiterState{}, nil
+}
+
+// Reset 无状态可清除
+func (DenyAllLimiter) Reset(context.Context) error {
+	return nil
+}
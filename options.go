@@ -0,0 +1,275 @@
+// Copyright(C) 2024 Github Inc. All Rights Reserved.
+// Author: metrue8@gmail.com
+// Date:   2024/01/03
+
+package ratelimiter
+
+import (
+	"math"
+	"time"
+
+	"github.com/spf13/cast"
+)
+
+// Option 限流器函数式参数, 通过 NewOptions/NewLimiter 按限流器类型转换为 Options 并校验
+//
+// 各限流器类型支持的参数:
+//   - 固定窗口/滑动窗口: WithLimit + WithWindow 或 WithRate, 可选 WithTTL
+//   - 令牌桶: WithLimit 或 WithBurst 作为桶上限, WithWindow(填满整桶的时间) 或 WithRate(令牌生成速率), 可选 WithTTL
+//   - 漏桶: WithLimit 或 WithBurst 作为桶容量, WithRate(漏水速率), 可选 WithTTL
+type Option func(v *optionValues)
+
+// optionValues 函数式参数收集的原始值
+type optionValues struct {
+	limit      int64          // 限流大小
+	window     time.Duration  // 时间窗口大小
+	burst      int64          // 突发容量
+	rateCount  int64          // 速率: 每 ratePeriod 内的请求数
+	ratePeriod time.Duration  // 速率: 统计周期
+	ttl        time.Duration  // Key 过期时间
+	initTokens *int64         // 令牌桶初始令牌数, 默认装满
+	period     CalendarPeriod // 日历配额周期
+	location   *time.Location // 日历配额周期对齐的时区
+}
+
+// WithLimit 设置限流大小(窗口限制数/令牌桶上限/漏桶容量)
+func WithLimit(n int64) Option {
+	return func(v *optionValues) {
+		v.limit = n
+	}
+}
+
+// WithWindow 设置时间窗口大小(固定窗口/滑动窗口的窗口, 令牌桶填满整桶的时间)
+func WithWindow(d time.Duration) Option {
+	return func(v *optionValues) {
+		v.window = d
+	}
+}
+
+// WithBurst 设置允许的突发容量(令牌桶上限/漏桶容量)
+func WithBurst(n int64) Option {
+	return func(v *optionValues) {
+		v.burst = n
+	}
+}
+
+// WithRate 设置速率为每 per 时间 n 个请求, 如 WithRate(100, time.Minute); 速率可不为整数, 如 WithRate(5, 2*time.Second) 即每秒 2.5 个
+func WithRate(n int64, per time.Duration) Option {
+	return func(v *optionValues) {
+		v.rateCount = n
+		v.ratePeriod = per
+	}
+}
+
+// WithTTL 设置限流 Key 的过期时间, 默认按窗口大小或漏空时间推导
+func WithTTL(d time.Duration) Option {
+	return func(v *optionValues) {
+		v.ttl = d
+	}
+}
+
+// WithPeriod 设置日历配额的周期(PeriodHour/PeriodDay/PeriodWeek/PeriodMonth), 仅日历配额限流器支持
+func WithPeriod(p CalendarPeriod) Option {
+	return func(v *optionValues) {
+		v.period = p
+	}
+}
+
+// WithLocation 设置日历配额周期对齐的时区, 如 time.LoadLocation("Asia/Shanghai"), 仅日历配额限流器支持
+func WithLocation(loc *time.Location) Option {
+	return func(v *optionValues) {
+		v.location = loc
+	}
+}
+
+// withInitTokens 设置令牌桶初始令牌数, 供 NewTokenBucketOption 适配使用
+func withInitTokens(n int64) Option {
+	return func(v *optionValues) {
+		v.initTokens = &n
+	}
+}
+
+// NewOptions 按限流器类型将函数式参数转换为限流器参数并校验, 非法参数返回 ErrInvalidOptions
+func NewOptions(limiterType LimiterType, opts ...Option) (Options, error) {
+	o, err := buildOptions(limiterType, opts...)
+	if err != nil {
+		return Options{}, err
+	}
+
+	if _, err := o.resolve(limiterType); err != nil {
+		return Options{}, err
+	}
+
+	return o, nil
+}
+
+// NewLimiter 使用默认客户端及函数式参数实例化限流器, 参数非法时返回错误
+func NewLimiter(product string, limiterType LimiterType, opts ...Option) (*RateLimiter, error) {
+	return defaultClient.NewLimiter(product, limiterType, opts...)
+}
+
+// NewLimiter 使用函数式参数实例化限流器, 参数非法时返回错误
+func (c *Client) NewLimiter(product string, limiterType LimiterType, opts ...Option) (*RateLimiter, error) {
+	o, err := NewOptions(limiterType, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.NewRateLimiter(product, limiterType, o), nil
+}
+
+// buildOptions 按限流器类型转换函数式参数, 只检查参数组合是否适用于该类型, 取值范围由 Options.resolve 校验
+func buildOptions(limiterType LimiterType, opts ...Option) (Options, error) {
+	var v optionValues
+	for _, opt := range opts {
+		opt(&v)
+	}
+
+	if limiterType != CalendarQuotaType && (v.period != 0 || v.location != nil) {
+		return Options{}, invalidOptionErr(limiterType, "does not support WithPeriod/WithLocation")
+	}
+
+	switch limiterType {
+	case FixedWindowType:
+		limit, unitTime, expiration, err := v.windowOptions(limiterType)
+		return Options{fixedWindowOptions: fixedWindowOptions{limitCount: limit, unitTime: unitTime, expiration: expiration}}, err
+	case SlideWindowType:
+		limit, unitTime, expiration, err := v.windowOptions(limiterType)
+		return Options{slideWindowOptions: slideWindowOptions{limitCount: limit, unitTime: unitTime, expiration: expiration}}, err
+	case TokenBucketType:
+		o, err := v.tokenBucket()
+		return Options{tokenBucketOptions: o}, err
+	case LeakyBucketType:
+		o, err := v.leakyBucket()
+		return Options{leakyBucketOptions: o}, err
+	case CalendarQuotaType:
+		o, err := v.calendarQuota()
+		return Options{calendarQuotaOptions: o}, err
+	}
+
+	return Options{}, unknownTypeErr(limiterType)
+}
+
+// windowOptions 转换固定窗口/滑动窗口参数, 返回限流大小、窗口大小(ms)、过期时间(ms)
+func (v optionValues) windowOptions(limiterType LimiterType) (limit, unitTime, expiration int64, err error) {
+	if v.burst != 0 {
+		return 0, 0, 0, invalidOptionErr(limiterType, "does not support WithBurst")
+	}
+
+	limit, window := v.limit, v.window
+	if v.ratePeriod != 0 {
+		if limit != 0 || window != 0 {
+			return 0, 0, 0, invalidOptionErr(limiterType, "WithRate conflicts with WithLimit/WithWindow")
+		}
+		limit, window = v.rateCount, v.ratePeriod
+	}
+
+	if unitTime, err = wholeMillis(limiterType, "window", window); err != nil {
+		return 0, 0, 0, err
+	}
+	if v.ttl != 0 && v.ttl < window {
+		return 0, 0, 0, invalidOptionErr(limiterType, "ttl %v must not be shorter than window %v", v.ttl, window)
+	}
+	if expiration, err = ttlMillis(limiterType, v.ttl); err != nil {
+		return 0, 0, 0, err
+	}
+
+	return limit, unitTime, expiration, nil
+}
+
+// tokenBucket 转换令牌桶参数
+func (v optionValues) tokenBucket() (o tokenBucketOptions, err error) {
+	if v.limit != 0 && v.burst != 0 && v.limit != v.burst {
+		return o, invalidOptionErr(TokenBucketType, "WithLimit %d conflicts with WithBurst %d", v.limit, v.burst)
+	}
+	o.maxTokens = v.limit
+	if v.burst != 0 {
+		o.maxTokens = v.burst
+	}
+
+	switch {
+	case v.ratePeriod != 0 && v.window != 0:
+		return o, invalidOptionErr(TokenBucketType, "WithRate conflicts with WithWindow")
+	case v.ratePeriod != 0:
+		if v.rateCount <= 0 || v.ratePeriod <= 0 {
+			return o, invalidOptionErr(TokenBucketType, "rate must be positive, got %d per %v", v.rateCount, v.ratePeriod)
+		}
+		// 令牌的产生间隔保留小数, 如 WithRate(1, 3*time.Second) 为 3000ms, WithRate(5, 2*time.Second) 为 400ms
+		o.permitInterval = float64(v.ratePeriod) / float64(time.Millisecond) / float64(v.rateCount)
+		if o.maxTokens == 0 {
+			o.maxTokens = v.rateCount
+		}
+	default:
+		if o.timeInterval, err = wholeMillis(TokenBucketType, "window", v.window); err != nil {
+			return o, err
+		}
+	}
+
+	o.initTokens = o.maxTokens
+	if v.initTokens != nil {
+		o.initTokens = *v.initTokens
+	}
+
+	// 过期时间短于填满整桶的时间时, 桶在恢复满额前过期并按初始令牌数重建, 相当于额外发放一次突发流量
+	if _, refill, _ := tokenBucketParams(o); v.ttl != 0 && float64(v.ttl) < refill*float64(time.Millisecond) {
+		return o, invalidOptionErr(TokenBucketType, "ttl %v must not be shorter than refill time %v", v.ttl, time.Duration(refill*float64(time.Millisecond)))
+	}
+	if o.expiration, err = ttlMillis(TokenBucketType, v.ttl); err != nil {
+		return o, err
+	}
+
+	return o, nil
+}
+
+// leakyBucket 转换漏桶参数
+func (v optionValues) leakyBucket() (o leakyBucketOptions, err error) {
+	if v.window != 0 {
+		return o, invalidOptionErr(LeakyBucketType, "does not support WithWindow, use WithRate")
+	}
+	if v.limit != 0 && v.burst != 0 && v.limit != v.burst {
+		return o, invalidOptionErr(LeakyBucketType, "WithLimit %d conflicts with WithBurst %d", v.limit, v.burst)
+	}
+	o.capacity = v.limit
+	if v.burst != 0 {
+		o.capacity = v.burst
+	}
+
+	// 漏水速率以每秒为单位, 可为小数, 如 WithRate(1, 3*time.Second) 为每秒 1/3 个请求
+	if v.rateCount <= 0 || v.ratePeriod <= 0 {
+		return o, invalidOptionErr(LeakyBucketType, "rate must be positive, got %d per %v", v.rateCount, v.ratePeriod)
+	}
+	o.leakRate = float64(v.rateCount) * float64(time.Second) / float64(v.ratePeriod)
+
+	if o.expiration, err = ttlMillis(LeakyBucketType, v.ttl); err != nil {
+		return o, err
+	}
+
+	return o, nil
+}
+
+// calendarQuota 转换日历配额参数, 周期长度及 Key 过期时间由日历决定
+func (v optionValues) calendarQuota() (o calendarQuotaOptions, err error) {
+	if v.window != 0 || v.ratePeriod != 0 || v.burst != 0 || v.ttl != 0 {
+		return o, invalidOptionErr(CalendarQuotaType, "does not support WithWindow/WithRate/WithBurst/WithTTL, use WithPeriod")
+	}
+
+	return calendarQuotaOptions{limitCount: v.limit, period: v.period, location: v.location}, nil
+}
+
+// wholeMillis 将时间转换为整数毫秒
+func wholeMillis(limiterType LimiterType, name string, d time.Duration) (int64, error) {
+	if d%time.Millisecond != 0 {
+		return 0, invalidOptionErr(limiterType, "%s %v must be a whole number of milliseconds", name, d)
+	}
+
+	return d.Milliseconds(), nil
+}
+
+// ttlMillis 将过期时间向上取整为毫秒, 为0时使用默认值
+func ttlMillis(limiterType LimiterType, ttl time.Duration) (int64, error) {
+	if ttl < 0 {
+		return 0, invalidOptionErr(limiterType, "ttl must be positive, got %v", ttl)
+	}
+
+	return cast.ToInt64(math.Ceil(float64(ttl) / float64(time.Millisecond))), nil
+}
@@ -0,0 +1,103 @@
+// Copyright(C) 2024 Github Inc. All Rights Reserved.
+// Author: metrue8@gmail.com
+// Date:   2024/01/03
+
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cast"
+)
+
+// penaltyKeySuffix 惩罚状态 Key 的后缀, 惩罚 Key 为不含窗口/分片后缀的存储Key加该后缀
+const penaltyKeySuffix = "penalty"
+
+// defaultMaxBanFactor 未设置最长封禁时长时, 最长封禁时长为首次封禁时长的倍数
+const defaultMaxBanFactor = 64
+
+// Penalty 惩罚策略: 周期内被拒绝次数达到阈值时封禁主体, 封禁时长随违规次数指数增长
+//
+// 首次封禁 Ban, 之后每次翻倍直至 MaxBan; 封禁结束后 MaxBan 时间内未再违规时, 违规次数清零
+type Penalty struct {
+	Threshold int64         // [V] 周期内被拒绝次数阈值
+	Period    time.Duration // [V] 统计被拒绝次数的周期
+	Ban       time.Duration // [V] 首次封禁时长
+	MaxBan    time.Duration // [-] 最长封禁时长, 默认为 Ban 的 64 倍
+}
+
+// validate 校验惩罚策略并补全默认值, 时间均需为整数毫秒
+func (p Penalty) validate() (Penalty, error) {
+	if p.MaxBan == 0 {
+		p.MaxBan = p.Ban * defaultMaxBanFactor
+	}
+
+	if p.Threshold <= 0 {
+		return p, fmt.Errorf("%w: penalty threshold must be positive, got %d", ErrInvalidOptions, p.Threshold)
+	}
+	for _, d := range []time.Duration{p.Period, p.Ban, p.MaxBan} {
+		if d < time.Millisecond || d%time.Millisecond != 0 {
+			return p, fmt.Errorf("%w: penalty durations must be positive whole milliseconds, got %v", ErrInvalidOptions, d)
+		}
+	}
+	if p.MaxBan < p.Ban {
+		return p, fmt.Errorf("%w: penalty max ban %v is less than ban %v", ErrInvalidOptions, p.MaxBan, p.Ban)
+	}
+
+	return p, nil
+}
+
+// WithPenalty 设置惩罚策略
+//
+// 设置后每次执行在同一个 Lua 脚本中先检查封禁状态再执行限流, 封禁期内直接拒绝, Decision.Reason 为 ReasonPenalty;
+// 惩罚状态保存在存储Key旁的 "::penalty" Key 中, 仅 Do/AllowN/Wait 生效, 不支持预约
+func (r *RateLimiter) WithPenalty(p Penalty) *RateLimiter {
+	r.penalty = &p
+	return r
+}
+
+// WithPenalty 设置惩罚策略, 见 RateLimiter.WithPenalty
+func (p *Policy) WithPenalty(penalty Penalty) *Policy {
+	p.limiter.WithPenalty(penalty)
+	return p
+}
+
+// penaltyKey 返回惩罚状态 Key
+func penaltyKey(baseKey string) string {
+	return baseKey + keySeparator + penaltyKeySuffix
+}
+
+// doPenaltyLimiter 在一次脚本调用中检查封禁状态并执行限流
+func (r *RateLimiter) doPenaltyLimiter(ctx context.Context, call limiterCall, n int64) (Decision, error) {
+	penalty, err := r.penalty.validate()
+	if err != nil {
+		return Decision{}, err
+	}
+
+	args := make([]interface{}, 0, 2+compositeRuleArgs+4)
+	args = append(args, n, call.now.UnixMilli())
+	args = append(args, compositeArgs(r.limiterType, call)...)
+	args = append(args, penalty.Threshold, penalty.Period.Milliseconds(), penalty.Ban.Milliseconds(), penalty.MaxBan.Milliseconds())
+
+	res, err := r.client.evalScript(ctx, "PenaltyScript", []string{call.key, penaltyKey(call.baseKey)}, args...)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	d, err := parseDecision(res, call.now)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	// 脚本额外返回是否封禁
+	if values := res.([]interface{}); len(values) > decisionFieldCount && cast.ToInt64(values[decisionFieldCount]) == 1 {
+		d.Reason = ReasonPenalty
+		if d.Limit == 0 {
+			d.Limit = call.options.limit(r.limiterType)
+		}
+	}
+
+	return d, nil
+}
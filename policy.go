@@ -0,0 +1,108 @@
+// Copyright(C) 2024 Github Inc. All Rights Reserved.
+// Author: metrue8@gmail.com
+// Date:   2024/01/03
+
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Policy 限流策略, 启动时配置一次, 每次调用按主体(用户ID、IP等)派生存储Key
+//
+// 同一业务线下的所有主体共用一个 Policy, 无需为每个请求创建限流器; 并发调用 Allow/AllowN 是安全的;
+// 所有 With 方法均直接修改策略, 应在共享给其他协程前完成配置, 不能与执行并发
+type Policy struct {
+	limiter   *RateLimiter // [V] 限流器模板, 参数在创建时已校验
+	options   Options      // [V] 补全默认值后的限流器参数, 创建时解析一次
+	dimension string       // [-] 主体对应的维度名称, 默认 DimSubject
+	keyPrefix string       // [V] 存储Key中主体取值之前的部分, 如 前缀::类型::业务线::subject=
+}
+
+// NewPolicy 使用默认客户端及函数式参数创建限流策略, 参数非法时返回错误
+func NewPolicy(product string, limiterType LimiterType, opts ...Option) (*Policy, error) {
+	return defaultClient.NewPolicy(product, limiterType, opts...)
+}
+
+// NewPolicy 使用函数式参数创建限流策略, 参数非法时返回错误
+func (c *Client) NewPolicy(product string, limiterType LimiterType, opts ...Option) (*Policy, error) {
+	o, err := NewOptions(limiterType, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// 创建时补全默认值并生成 Key 前缀, 每次调用仅拼接主体取值
+	limiter := c.NewRateLimiter(product, limiterType, o)
+	resolved, err := limiter.resolveOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Policy{
+		limiter: limiter,
+		options: resolved,
+	}
+	return p.WithDimension(DimSubject), nil
+}
+
+// WithDimension 设置主体对应的维度名称(如 DimUser、DimIP)
+func (p *Policy) WithDimension(name string) *Policy {
+	if len(name) > 0 {
+		p.dimension = name
+		p.keyPrefix = p.limiter.keyBuilder().Build() + keySeparator + keyEscaper.Replace(name) + "="
+		p.limiter.WithSubjectDimension(name)
+	}
+
+	return p
+}
+
+// WithDryRun 设置是否试运行, 见 RateLimiter.WithDryRun
+func (p *Policy) WithDryRun(dryRun bool) *Policy {
+	p.limiter.WithDryRun(dryRun)
+	return p
+}
+
+// Allow 为主体消耗一个许可
+func (p *Policy) Allow(ctx context.Context, subject string) (Decision, error) {
+	return p.AllowN(ctx, subject, 1)
+}
+
+// AllowN 为主体一次性消耗 n 个许可, 许可不足时整体拒绝, 不会部分消耗
+func (p *Policy) AllowN(ctx context.Context, subject string, n int64) (Decision, error) {
+	if len(subject) == 0 {
+		return Decision{}, fmt.Errorf("%w: empty subject", ErrInvalidOptions)
+	}
+
+	ret, _, err := p.limiter.executeCall(ctx, n, false, func(now time.Time) (limiterCall, error) {
+		return p.newCall(now, subject), nil
+	})
+	return ret, err
+}
+
+// newCall 按主体生成单次调用的执行参数, 直接复用创建时解析的参数及 Key 前缀
+func (p *Policy) newCall(now time.Time, subject string) limiterCall {
+	call := limiterCall{
+		baseKey: p.keyPrefix + keyEscaper.Replace(subject),
+		now:     now,
+		options: p.options,
+		subject: subject,
+	}
+	call.key = p.limiter.genLimiterKey(call.baseKey, call.options, now)
+
+	return call
+}
+
+// Limiter 返回主体对应的限流器, 用于 Wait/Reserve/Inspect/Reset 等操作
+func (p *Policy) Limiter(subject string) *RateLimiter {
+	limiter := *p.limiter
+	limiter.dimensions = p.keyBuilder(subject).Dimensions()
+
+	return &limiter
+}
+
+// keyBuilder 返回主体对应的 Key 构造器
+func (p *Policy) keyBuilder(subject string) KeyBuilder {
+	return p.limiter.keyBuilder().With(p.dimension, subject)
+}
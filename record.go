@@ -1,17 +1,48 @@
 package ratelimiter
 
 import (
+	"log"
 	"sync"
 	"time"
 )
 
+// defaultRecordBuffer 默认限流记录通道容量
+const defaultRecordBuffer = 10000
+
 // LimiterRecord 限流记录结构体
 type LimiterRecord struct {
-	Type      LimiterType // 限流器类型
-	Key       string      // Redis Key
-	Result    int64       // 限流结果
-	Timestamp time.Time   // 执行时间
-	Error     error       // 错误信息
+	Type      LimiterType   // 限流器类型
+	Key       string        // Redis Key
+	Result    Decision      // 限流结果
+	Timestamp time.Time     // 执行时间
+	Error     error         // 错误信息, 按故障处理策略决策时为 Redis 的原始错误
+	Fallback  FailurePolicy // 按故障处理策略决策时所采用的策略, 为 FailError 表示由 Redis 决策
+	DryRun    bool          // 是否为试运行限流器的记录, 为 true 时 Result 为实际决策, 调用方得到的始终是放行
+	Event     RecordEvent   // 记录类型, 本地限流的启用与恢复也通过限流记录上报, 此时仅 Timestamp/Error 有意义
+}
+
+// RecordEvent 限流记录类型
+type RecordEvent int
+
+// 定义限流记录类型
+const (
+	EventDecision          RecordEvent = iota // 限流决策, 默认类型
+	EventFallbackActivated                    // Redis 执行失败, 启用本地限流, Error 为触发启用的错误
+	EventFallbackRecovered                    // Redis 健康检查恢复, 停用本地限流
+)
+
+// String 返回限流记录类型名称
+func (e RecordEvent) String() string {
+	switch e {
+	case EventDecision:
+		return "Decision"
+	case EventFallbackActivated:
+		return "FallbackActivated"
+	case EventFallbackRecovered:
+		return "FallbackRecovered"
+	}
+
+	return "RecordEvent(unknown)"
 }
 
 // RecordHandler 记录处理接口
@@ -19,40 +50,91 @@ type RecordHandler interface {
 	Handle(record LimiterRecord)
 }
 
-// 全局变量定义
-var (
-	recordChan   = make(chan LimiterRecord, 10000) // 限流记录通道
-	handlers     = make(map[string]RecordHandler)  // 记录处理器映射
-	handlerMutex sync.RWMutex                      // 限流记录处理函数互斥锁
-)
+// recorder 限流记录通道, 异步将记录分发给已注册的处理器
+type recorder struct {
+	records   chan LimiterRecord       // 限流记录通道
+	handlers  map[string]RecordHandler // 记录处理器映射
+	mutex     sync.RWMutex             // 限流记录处理函数互斥锁
+	done      chan struct{}            // 关闭信号
+	closeOnce sync.Once                // 保证只关闭一次
+}
+
+// defaultRecorder 默认客户端使用的限流记录通道, 包级 RegisterHandler 注册到此处
+var defaultRecorder = newRecorder(defaultRecordBuffer)
+
+// newRecorder 创建限流记录通道并启动处理协程
+func newRecorder(size int) *recorder {
+	rec := &recorder{
+		records:  make(chan LimiterRecord, size),
+		handlers: make(map[string]RecordHandler),
+		done:     make(chan struct{}),
+	}
+	go rec.process()
 
-func init() {
-	recordChan = make(chan LimiterRecord, 10000)
-	go processRecords()
+	return rec
 }
 
-// RegisterHandler 注册结果处理器
+// RegisterHandler 注册默认客户端的结果处理器
 func RegisterHandler(name string, handler RecordHandler) {
-	handlerMutex.Lock()
-	defer handlerMutex.Unlock()
-	handlers[name] = handler
+	defaultRecorder.register(name, handler)
 }
 
-// UnregisterHandler 注销结果处理器
+// UnregisterHandler 注销默认客户端的结果处理器
 func UnregisterHandler(name string) {
-	handlerMutex.Lock()
-	delete(handlers, name)
-	handlerMutex.Unlock()
-}
-
-// processRecords 处理记录的协程
-func processRecords() {
-	for result := range recordChan {
-		handlerMutex.RLock()
-		// 将结果传递给所有处理器(handlers 为空时不执行此处)
-		for _, handler := range handlers {
-			handler.Handle(result)
+	defaultRecorder.unregister(name)
+}
+
+// register 注册结果处理器
+func (rec *recorder) register(name string, handler RecordHandler) {
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+	rec.handlers[name] = handler
+}
+
+// unregister 注销结果处理器
+func (rec *recorder) unregister(name string) {
+	rec.mutex.Lock()
+	delete(rec.handlers, name)
+	rec.mutex.Unlock()
+}
+
+// send 发送限流记录, 通道已满或已关闭时丢弃
+func (rec *recorder) send(record LimiterRecord) {
+	select {
+	case <-rec.done:
+		return
+	default:
+	}
+
+	select {
+	case rec.records <- record:
+		// 成功发送到通道
+	default:
+		// 通道已满，记录丢弃事件
+		log.Printf("Warning: Record channel full, dropping record for key: %s", record.Key)
+	}
+}
+
+// close 停止处理协程
+func (rec *recorder) close() {
+	rec.closeOnce.Do(func() {
+		close(rec.done)
+	})
+}
+
+// process 处理记录的协程
+func (rec *recorder) process() {
+	for {
+		select {
+		case <-rec.done:
+			return
+		case result := <-rec.records:
+			rec.mutex.RLock()
+			// 将结果传递给所有处理器(handlers 为空时不执行此处)
+			for _, handler := range rec.handlers {
+				handler.Handle(result)
+			}
+			rec.mutex.RUnlock()
 		}
-		handlerMutex.RUnlock()
 	}
 }
@@ -25,7 +25,7 @@ func (h *LogHandler) Handle(record LimiterRecord) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 	h.records = append(h.records, record)
-	log.Printf("Limiter[%s] Key[%s] Result[%d] Error[%v]",
+	log.Printf("Limiter[%s] Key[%s] Result[%+v] Error[%v]",
 		record.Type, record.Key, record.Result, record.Error)
 }
 
@@ -40,35 +40,40 @@ func TestRecordHandler(t *testing.T) {
 		name        string
 		limiterType LimiterType
 		options     Options
-		wantResult  int64
+		wantAllowed bool
+		wantLimit   int64
 		wantError   bool
 	}{
 		{
 			name:        "固定窗口限流-正常",
 			limiterType: FixedWindowType,
 			options:     NewFixedWindowOption(10, 1),
-			wantResult:  10,
+			wantAllowed: true,
+			wantLimit:   10,
 			wantError:   false,
 		},
 		{
 			name:        "滑动窗口限流-正常",
 			limiterType: SlideWindowType,
 			options:     NewSlideWindowOption(10, 1),
-			wantResult:  0,
+			wantAllowed: true,
+			wantLimit:   10,
 			wantError:   false,
 		},
 		{
 			name:        "令牌桶限流-正常",
 			limiterType: TokenBucketType,
 			options:     NewTokenBucketOption(10, 1, 5),
-			wantResult:  5,
+			wantAllowed: true,
+			wantLimit:   10,
 			wantError:   false,
 		},
 		{
 			name:        "漏桶限流-正常",
 			limiterType: LeakyBucketType,
 			options:     NewLeakyBucketOption(10, 1),
-			wantResult:  1,
+			wantAllowed: true,
+			wantLimit:   10,
 			wantError:   false,
 		},
 	}
@@ -92,7 +97,8 @@ func TestRecordHandler(t *testing.T) {
 				assert.Error(t, err)
 			} else {
 				assert.NoError(t, err)
-				assert.Equal(t, tt.wantResult, result)
+				assert.Equal(t, tt.wantAllowed, result.Allowed)
+				assert.Equal(t, tt.wantLimit, result.Limit)
 			}
 
 			// 验证记录
@@ -6,11 +6,12 @@ package ratelimiter
 
 import (
 	"context"
+	"strings"
 
 	"github.com/redis/go-redis/v9"
 )
 
-// NoScriptMsg 定义无脚本执行的信息
+// NoScriptMsg 定义无脚本执行的信息, 内部按 NOSCRIPT 错误前缀判断, 保留用于兼容
 const NoScriptMsg string = "NOSCRIPT No matching script. Please use EVAL."
 
 // LoadScript 执行脚本加载
@@ -34,33 +35,70 @@ func ScriptFlush(ctx context.Context, client *redis.Client) bool {
 
 // EvalSha 通过Sha值执行脚本
 func EvalSha(ctx context.Context, client *redis.Client, sha1 string, keys []string, args ...interface{}) (interface{}, error) {
-	cmdArgs := make([]interface{}, 3+len(keys), 3+len(keys)+len(args))
-	cmdArgs[0] = "EVALSHA"
-	cmdArgs[1] = sha1
-	cmdArgs[2] = len(keys)
-	for i, key := range keys {
-		cmdArgs[3+i] = key
-	}
-	cmdArgs = append(cmdArgs, args...)
-	res, err := client.Do(ctx, cmdArgs...).Result()
-	if err != nil && err.Error() == NoScriptMsg {
-		// 缺失脚本时重新异步Load
-		go func(client *redis.Client) {
-			loadRedisScript(client)
-		}(client)
+	res, err := evalSha(ctx, client, sha1, keys, args...)
+	if isNoScriptErr(err) {
+		// 缺失脚本时按默认客户端的折叠代码标记重新异步Load
+		go loadScripts(context.TODO(), client, defaultClient.scripts)
 	}
 	return res, err
 }
 
+// evalSha 通过Sha值执行脚本, 脚本缓存丢失时由调用方使用脚本重查
+func evalSha(ctx context.Context, client *redis.Client, sha1 string, keys []string, args ...interface{}) (interface{}, error) {
+	return client.Do(ctx, scriptCmdArgs("EVALSHA", sha1, keys, args)...).Result()
+}
+
 // Eval 执行脚本
 func Eval(ctx context.Context, client *redis.Client, script string, keys []string, args ...interface{}) (interface{}, error) {
+	return client.Do(ctx, scriptCmdArgs("EVAL", script, keys, args)...).Result()
+}
+
+// EvalShaRO 通过Sha值执行只读脚本, 集群开启 ReadOnly 时可被路由到从库; Redis 7.0 以下自动降级为 EVALSHA
+func EvalShaRO(ctx context.Context, client *redis.Client, sha1 string, keys []string, args ...interface{}) (interface{}, error) {
+	res, err := evalShaRO(ctx, client, sha1, keys, args...)
+	if isUnknownCommandErr(err) {
+		return evalSha(ctx, client, sha1, keys, args...)
+	}
+	return res, err
+}
+
+// EvalRO 执行只读脚本, Redis 7.0 以下自动降级为 EVAL
+func EvalRO(ctx context.Context, client *redis.Client, script string, keys []string, args ...interface{}) (interface{}, error) {
+	res, err := evalRO(ctx, client, script, keys, args...)
+	if isUnknownCommandErr(err) {
+		return Eval(ctx, client, script, keys, args...)
+	}
+	return res, err
+}
+
+// evalShaRO 通过Sha值执行只读脚本(EVALSHA_RO, 自 Redis 7.0 起支持), 不做降级
+func evalShaRO(ctx context.Context, client *redis.Client, sha1 string, keys []string, args ...interface{}) (interface{}, error) {
+	return client.Do(ctx, scriptCmdArgs("EVALSHA_RO", sha1, keys, args)...).Result()
+}
+
+// evalRO 执行只读脚本(EVAL_RO, 自 Redis 7.0 起支持), 不做降级
+func evalRO(ctx context.Context, client *redis.Client, script string, keys []string, args ...interface{}) (interface{}, error) {
+	return client.Do(ctx, scriptCmdArgs("EVAL_RO", script, keys, args)...).Result()
+}
+
+// scriptCmdArgs 组装脚本执行命令参数
+func scriptCmdArgs(cmd, script string, keys []string, args []interface{}) []interface{} {
 	cmdArgs := make([]interface{}, 3+len(keys), 3+len(keys)+len(args))
-	cmdArgs[0] = "EVAL"
+	cmdArgs[0] = cmd
 	cmdArgs[1] = script
 	cmdArgs[2] = len(keys)
 	for i, key := range keys {
 		cmdArgs[3+i] = key
 	}
-	cmdArgs = append(cmdArgs, args...)
-	return client.Do(ctx, cmdArgs...).Result()
+	return append(cmdArgs, args...)
+}
+
+// isNoScriptErr 判断是否为脚本缓存丢失的错误
+func isNoScriptErr(err error) bool {
+	return err != nil && redis.HasErrorPrefix(err, "NOSCRIPT")
+}
+
+// isUnknownCommandErr 判断是否为 Redis 不支持该命令的错误
+func isUnknownCommandErr(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), "ERR unknown command")
 }
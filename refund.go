@@ -0,0 +1,63 @@
+// Copyright(C) 2024 Github Inc. All Rights Reserved.
+// Author: metrue8@gmail.com
+// Date:   2024/01/03
+
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+)
+
+// Refund 归还 d 中已消耗的 n 个许可, 适用于请求在实际处理前失败(参数校验失败、下游不可用等)的场景
+//
+// 归还需要传入放行时的 Decision 而非仅传 n: 固定窗口的 Key 随窗口滚动、大容量限流的分片后缀随机选取, 限流器本身无法得知某次放行扣减了哪个 Key 及多少许可.
+// 许可归还到 d.Key, n 超过该决策剩余可归还的数量时按剩余数量归还, 归还后从 d 中扣除, 同一决策不会被重复归还;
+// d 被拒绝时返回 ErrInvalidOptions, 名单、降级、试运行等未扣减 Redis 状态的放行决策(d.Key 为空)无需归还.
+// 固定窗口及日历配额扣减对应窗口计数, 滑动窗口从最新的小格子开始扣减, 令牌桶归还令牌且不超过上限, 漏桶取出对应水量
+func (r *RateLimiter) Refund(ctx context.Context, d *Decision, n int64) error {
+	if n <= 0 {
+		return invalidPermitsErr(n)
+	}
+
+	switch {
+	case d == nil || !d.Allowed:
+		return fmt.Errorf("%w: cannot refund a rejected decision", ErrInvalidOptions)
+	case len(d.Key) == 0:
+		return nil
+	case d.cost <= 0:
+		return fmt.Errorf("%w: decision has already been refunded", ErrInvalidOptions)
+	}
+
+	n = minInt64(n, d.cost)
+	if err := r.refund(ctx, d.Key, n); err != nil {
+		return err
+	}
+
+	d.cost -= n
+	return nil
+}
+
+// refund 向指定 Key 归还 n 个许可
+func (r *RateLimiter) refund(ctx context.Context, key string, n int64) error {
+	var (
+		name string
+		args = []interface{}{n}
+	)
+	switch r.limiterType {
+	case FixedWindowType, CalendarQuotaType:
+		name = "FixedWindowRefundScript"
+	case SlideWindowType:
+		name = "SlideWindowRefundScript"
+	case TokenBucketType:
+		name = "TokenBucketRefundScript"
+		args = append(args, r.options.tokenBucketOptions.maxTokens)
+	case LeakyBucketType:
+		name = "LeakyBucketRefundScript"
+	default:
+		return unknownTypeErr(r.limiterType)
+	}
+
+	_, err := r.client.evalScript(ctx, name, []string{key}, args...)
+	return err
+}
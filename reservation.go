@@ -0,0 +1,100 @@
+// Copyright(C) 2024 Github Inc. All Rights Reserved.
+// Author: metrue8@gmail.com
+// Date:   2024/01/03
+
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Reservation 预约许可结果, 记录预约到的许可在何时可以执行
+type Reservation struct {
+	ok        bool         // 是否预约成功
+	limiter   *RateLimiter // 预约所属限流器
+	key       string       // 预约实际扣减的 Redis Key, 未扣减 Redis 状态(名单、降级、试运行)时为空
+	tokens    int64        // 预约的许可数
+	timeToAct time.Time    // 许可可执行的时间
+	decision  Decision     // 预约时的限流决策结果
+}
+
+// Reserve 预约一个许可, 仅令牌桶与漏桶限流器支持
+func (r *RateLimiter) Reserve(ctx context.Context) (*Reservation, error) {
+	return r.ReserveN(ctx, 1)
+}
+
+// ReserveN 预约 n 个许可, 仅令牌桶与漏桶限流器支持
+//
+// 许可不足时令牌桶允许透支、漏桶允许排队, 调用方需等待 Delay 之后再执行; 放弃执行时应调用 Cancel 归还许可
+func (r *RateLimiter) ReserveN(ctx context.Context, n int64) (*Reservation, error) {
+	if r.limiterType != TokenBucketType && r.limiterType != LeakyBucketType {
+		return nil, fmt.Errorf("%w: limiter type %s does not support reservation", ErrUnsupported, r.limiterType)
+	}
+
+	res, call, err := r.execute(ctx, n, true)
+	if err != nil {
+		return nil, err
+	}
+
+	reservation := &Reservation{
+		ok:       res.Allowed,
+		limiter:  r,
+		key:      res.Key,
+		tokens:   n,
+		decision: res,
+	}
+	if res.Allowed {
+		reservation.timeToAct = call.now.Add(res.RetryAfter)
+	}
+
+	return reservation, nil
+}
+
+// OK 是否预约成功, 预约失败时 Delay 无意义
+func (r *Reservation) OK() bool {
+	return r.ok
+}
+
+// Decision 返回预约时的限流决策结果
+func (r *Reservation) Decision() Decision {
+	return r.decision
+}
+
+// Delay 距离许可可执行还需等待的时间, 为0表示可立即执行
+func (r *Reservation) Delay() time.Duration {
+	return r.DelayFrom(r.limiter.client.now())
+}
+
+// DelayFrom 从指定时间起算, 距离许可可执行还需等待的时间
+func (r *Reservation) DelayFrom(t time.Time) time.Duration {
+	if !r.ok {
+		return 0
+	}
+
+	delay := r.timeToAct.Sub(t)
+	if delay < 0 {
+		return 0
+	}
+
+	return delay
+}
+
+// Cancel 放弃预约并将许可归还到 Redis, 许可已到可执行时间时视为已使用, 不再归还
+//
+// 仅归还由 Redis 限流算法实际扣减的许可; 白名单、本地限流、FailOpen 及试运行放行的预约未消耗 Redis 状态, 取消时忽略
+func (r *Reservation) Cancel(ctx context.Context) error {
+	if !r.ok || len(r.key) == 0 || !r.limiter.client.now().Before(r.timeToAct) {
+		return nil
+	}
+
+	if err := r.limiter.refund(ctx, r.key, r.tokens); err != nil {
+		return err
+	}
+
+	// 仅归还一次
+	r.ok = false
+
+	return nil
+}
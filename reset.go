@@ -0,0 +1,117 @@
+// Copyright(C) 2024 Github Inc. All Rights Reserved.
+// Author: metrue8@gmail.com
+// Date:   2024/01/03
+
+package ratelimiter
+
+import (
+	"context"
+	"strings"
+)
+
+// resetScanCount 每次 SCAN 扫描的 Key 数量
+const resetScanCount int64 = 1000
+
+// Reset 清除限流器在 Redis 中的全部状态, 用于立即解除对客户的限流
+//
+// 自定义 RedisKey 时仅删除该 Key 及其惩罚状态, 否则删除该限流器自身存储Key的所有分片、固定窗口的所有时间窗口及惩罚状态;
+// 设置了维度时仅删除该维度取值对应的 Key, 未设置维度时仅删除不含维度的 Key, 不影响同一业务线下按维度派生的 Key.
+// 需要清除业务线下全部维度取值时使用 ResetProduct
+func (r *RateLimiter) Reset(ctx context.Context) error {
+	if len(r.customKey) > 0 {
+		return wrapBackendErr(r.client.rdb.Del(ctx, r.customKey, penaltyKey(r.customKey)).Err())
+	}
+
+	return r.client.resetKeys(ctx, r.keyBuilder(), false)
+}
+
+// ResetProduct 使用默认客户端清除业务线下指定类型限流器的全部状态, 包括所有 ::mod 分片后缀及固定窗口的所有时间戳后缀
+//
+// 使用 SCAN 遍历匹配的 Key, 不会像 KEYS 一样阻塞 Redis
+func ResetProduct(ctx context.Context, product string, limiterType LimiterType) error {
+	return defaultClient.ResetProduct(ctx, product, limiterType)
+}
+
+// ResetProduct 通过 SCAN 删除业务线下指定类型限流器由 genLimiterKey 生成的全部 Key, 包括所有维度取值
+func (c *Client) ResetProduct(ctx context.Context, product string, limiterType LimiterType) error {
+	if !limiterType.valid() {
+		return unknownTypeErr(limiterType)
+	}
+
+	return c.resetKeys(ctx, c.KeyBuilder(limiterType, product), true)
+}
+
+// resetKeys 通过 SCAN 删除以构造器 Key 为前缀的限流 Key, withDimensions 为 true 时同时删除追加了其他维度的 Key
+func (c *Client) resetKeys(ctx context.Context, b KeyBuilder, withDimensions bool) error {
+	prefix := b.Build() + keySeparator
+	match := escapeGlobPattern(prefix) + "*"
+
+	var cursor uint64
+	for {
+		keys, next, err := c.rdb.Scan(ctx, cursor, match, resetScanCount).Result()
+		if err != nil {
+			return wrapBackendErr(err)
+		}
+
+		// 仅删除后缀符合 genLimiterKey 格式的 Key, 避免误删前缀相同的自定义 Key
+		dels := make([]string, 0, len(keys))
+		for _, key := range keys {
+			if isLimiterKeySuffix(strings.TrimPrefix(key, prefix), b.limiterType, withDimensions) {
+				dels = append(dels, key)
+			}
+		}
+		if len(dels) > 0 {
+			if err := c.rdb.Del(ctx, dels...).Err(); err != nil {
+				return wrapBackendErr(err)
+			}
+		}
+
+		cursor = next
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// isLimiterKeySuffix 判断是否为 genLimiterKey 生成的后缀: 固定窗口及日历配额为 "窗口::分片", 其余为 "分片", 惩罚状态为 "penalty"; withDimensions 为 true 时允许前置 "维度名=维度值"
+func isLimiterKeySuffix(suffix string, limiterType LimiterType, withDimensions bool) bool {
+	parts := strings.Split(suffix, keySeparator)
+	for withDimensions && len(parts) > 0 && strings.Contains(parts[0], "=") {
+		parts = parts[1:]
+	}
+
+	// 惩罚状态 Key
+	if len(parts) == 1 && parts[0] == penaltyKeySuffix {
+		return true
+	}
+
+	want := 1
+	if limiterType.windowed() {
+		want = 2
+	}
+	if len(parts) != want {
+		return false
+	}
+
+	for _, part := range parts {
+		if len(part) == 0 || strings.Trim(part, "0123456789") != "" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// escapeGlobPattern 转义 SCAN MATCH 中的通配符
+func escapeGlobPattern(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(c)
+	}
+
+	return b.String()
+}
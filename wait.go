@@ -0,0 +1,64 @@
+// Copyright(C) 2024 Github Inc. All Rights Reserved.
+// Author: metrue8@gmail.com
+// Date:   2024/01/03
+
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// waitJitterRatio 等待时追加的随机抖动占重试间隔的最大比例, 防止多个实例在同一时刻集中重试同一个 Key
+const waitJitterRatio = 0.2
+
+// Wait 阻塞等待直到获取一个许可
+func (r *RateLimiter) Wait(ctx context.Context) error {
+	return r.WaitN(ctx, 1)
+}
+
+// WaitN 阻塞等待直到获取 n 个许可
+//
+// 每次被拒绝后按脚本返回的重试间隔加随机抖动休眠后重试, 若上下文截止时间内无法获取许可则提前返回错误
+func (r *RateLimiter) WaitN(ctx context.Context, n int64) error {
+	for {
+		res, err := r.AllowN(ctx, n)
+		if err != nil {
+			return err
+		}
+		if res.Allowed {
+			return nil
+		}
+
+		// 单次消耗超过限流大小, 等待没有意义
+		if res.RetryAfter < 0 {
+			return fmt.Errorf("%w: wait %d permits exceeds limit %d", ErrLimited, n, res.Limit)
+		}
+
+		delay := res.RetryAfter + waitJitter(res.RetryAfter)
+		if deadline, ok := ctx.Deadline(); ok {
+			remain := time.Until(deadline)
+			if remain < res.RetryAfter {
+				return fmt.Errorf("%w: wait %d permits would exceed context deadline, retry after %v", ErrLimited, n, res.RetryAfter)
+			}
+			if delay > remain {
+				delay = remain
+			}
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// waitJitter 计算重试间隔的随机抖动
+func waitJitter(delay time.Duration) time.Duration {
+	return time.Duration(rand.Int63n(int64(float64(delay)*waitJitterRatio) + int64(time.Millisecond)))
+}